@@ -0,0 +1,80 @@
+package shuffle
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+)
+
+// ShardMany computes the simple shuffle shards of many identifications at
+// once, see SimpleShuffleShard. The lattice is only inspected once for the
+// whole batch; the shards are keyed by the string form of the identifiers.
+func (l *Lattice) ShardMany(ids [][]byte, epc int) (map[string]*Lattice, error) {
+	return l.ShardManyWorkers(ids, epc, 1)
+}
+
+// ShardManyWorkers is like ShardMany, but it fans the identifications out
+// across the given number of goroutines. The lattice must not be modified
+// until it returns.
+func (l *Lattice) ShardManyWorkers(ids [][]byte, epc, workers int) (
+	map[string]*Lattice, error,
+) {
+	if workers < 1 {
+		return nil, fmt.Errorf("shard: at least one worker is required")
+	}
+
+	shards, errs := l.shardMany(ids, epc, workers)
+
+	m := make(map[string]*Lattice, len(ids))
+	for i, id := range ids {
+		if errs[i] != nil {
+			return nil, fmt.Errorf(
+				"shard: unable to shard %q: %v", id, errs[i],
+			)
+		}
+		m[string(id)] = shards[i]
+	}
+
+	return m, nil
+}
+
+// shardMany computes the shard of each identification, or the error of
+// computing it, in the order of the identifications.
+func (l *Lattice) shardMany(ids [][]byte, epc, workers int) (
+	[]*Lattice, []error,
+) {
+	var (
+		t      *shardTable
+		shards []*Lattice
+		errs   []error
+		next   chan int
+		wg     sync.WaitGroup
+	)
+
+	t = newShardTable(l)
+	shards = make([]*Lattice, len(ids))
+	errs = make([]error, len(ids))
+	next = make(chan int)
+
+	// Each worker writes to its own slots, no locking is needed.
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Seeding a generator is cheaper than allocating one.
+			r := rand.New(rand.NewSource(0))
+			for i := range next {
+				shards[i], errs[i] = t.shard(r, ids[i], epc, t.capacity)
+			}
+		}()
+	}
+
+	for i := range ids {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	return shards, errs
+}
@@ -0,0 +1,123 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// batchLattice creates a 3x3 lattice with 10 endpoints per cell.
+func batchLattice(t testing.TB) *shuffle.Lattice {
+	lat, err := shuffle.NewLatticeWithSeed(42, []string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+
+	for _, az := range []string{"x", "y", "z"} {
+		for _, v := range []string{"1", "2", "3"} {
+			var eps []string
+			for i := 0; i < 10; i++ {
+				eps = append(eps, fmt.Sprintf("%s%s-%d", az, v, i))
+			}
+			lat.AddEndpointsForSector([]string{az, v}, eps)
+		}
+	}
+
+	return lat
+}
+
+// batchIDs creates n tenant identifications.
+func batchIDs(n int) [][]byte {
+	ids := make([][]byte, n)
+	for i := range ids {
+		ids[i] = []byte(fmt.Sprintf("tenant-%d", i))
+	}
+
+	return ids
+}
+
+// TestShardMany checks that batched shards match the per-call shards.
+func TestShardMany(t *testing.T) {
+	lat := batchLattice(t)
+	ids := batchIDs(1000)
+
+	for _, workers := range []int{1, 4} {
+		shards, err := lat.ShardManyWorkers(ids, 2, workers)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		if len(shards) != len(ids) {
+			t.Fatalf(
+				"illegal number of shards returned: expected %d, "+
+					"but got: %d", len(ids), len(shards),
+			)
+		}
+
+		for _, id := range ids {
+			shd, err := lat.SimpleShuffleShard(id, 2)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			got := fmt.Sprint(shards[string(id)].EndpointsByCoordinate)
+			exp := fmt.Sprint(shd.EndpointsByCoordinate)
+			if got != exp {
+				t.Fatalf(
+					"batched shard of %s differs: expected %s, but got: %s",
+					id, exp, got,
+				)
+			}
+		}
+	}
+
+	// Errors are reported for the whole batch.
+	if _, err := lat.ShardMany(ids, 11); err == nil {
+		t.Fatalf("expected error for too many endpoints per cell")
+	}
+	if _, err := lat.ShardManyWorkers(ids, 2, 0); err == nil {
+		t.Fatalf("expected error for no workers")
+	}
+}
+
+// BenchmarkSimpleShuffleShard shards a batch of tenants one call at a time.
+func BenchmarkSimpleShuffleShard(b *testing.B) {
+	lat := batchLattice(b)
+	ids := batchIDs(1000)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, id := range ids {
+			if _, err := lat.SimpleShuffleShard(id, 2); err != nil {
+				b.Fatalf("unable to shard the lattice: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkShardMany shards a batch of tenants with ShardMany.
+func BenchmarkShardMany(b *testing.B) {
+	lat := batchLattice(b)
+	ids := batchIDs(1000)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := lat.ShardMany(ids, 2); err != nil {
+			b.Fatalf("unable to shard the lattice: %v", err)
+		}
+	}
+}
+
+// BenchmarkShardManyWorkers shards a batch of tenants with a worker pool.
+func BenchmarkShardManyWorkers(b *testing.B) {
+	lat := batchLattice(b)
+	ids := batchIDs(1000)
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := lat.ShardManyWorkers(ids, 2, 4); err != nil {
+			b.Fatalf("unable to shard the lattice: %v", err)
+		}
+	}
+}
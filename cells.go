@@ -0,0 +1,14 @@
+//go:build go1.23
+// +build go1.23
+
+package shuffle
+
+import "iter"
+
+// Cells returns an iterator over the sectors and the endpoints of the cells
+// of the lattice, in the order of ForEachCell, and with the same rules.
+func (l *Lattice) Cells() iter.Seq2[[]string, []string] {
+	return func(yield func(sec, eps []string) bool) {
+		l.ForEachCell(yield)
+	}
+}
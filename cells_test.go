@@ -0,0 +1,29 @@
+//go:build go1.23
+// +build go1.23
+
+package shuffle_test
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCells tests the iterator over the cells of a lattice.
+func TestCells(t *testing.T) {
+	l := batchLattice(t)
+
+	var got []string
+	for sec, eps := range l.Cells() {
+		if len(eps) != 10 {
+			t.Fatalf("illegal endpoints for sector %v: %v", sec, eps)
+		}
+		got = append(got, strings.Join(sec, ""))
+		if sec[0] == "y" {
+			break
+		}
+	}
+
+	if s := strings.Join(got, ", "); s != "x1, x2, x3, y1" {
+		t.Fatalf("illegal cells: %q", s)
+	}
+}
@@ -0,0 +1,162 @@
+package shuffle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ShardChange describes how the simple shuffle shard of a tenant changes
+// between two versions of a lattice.
+type ShardChange struct {
+	ID []byte
+
+	// Endpoints which are only in the new shard, or only in the old one.
+	AddedEndpoints   []string
+	RemovedEndpoints []string
+
+	// Cells (as sectors) which are only in the new shard, or only in the
+	// old one.
+	AddedCells   [][]string
+	RemovedCells [][]string
+
+	// Err is set when the tenant cannot be sharded on one of the versions
+	// of the lattice. That version is then taken to give it no endpoints,
+	// so a tenant which can no longer be sharded loses its whole shard.
+	Err error
+}
+
+// Moved tells whether the shard of the tenant changed at all.
+func (c ShardChange) Moved() bool {
+	return len(c.AddedEndpoints) > 0 || len(c.RemovedEndpoints) > 0 ||
+		len(c.AddedCells) > 0 || len(c.RemovedCells) > 0
+}
+
+// ShardChurn sums up the changes of the shards of a set of tenants between
+// two versions of a lattice.
+type ShardChurn struct {
+	// Changes holds the change of each tenant, in the order of the
+	// identifications given to DiffShards.
+	Changes []ShardChange
+
+	// Tenants is the number of tenants, Moved the number of tenants whose
+	// shard changed, and Failed the number of tenants with an error.
+	Tenants       int
+	Moved         int
+	MovedFraction float64
+	Failed        int
+
+	// EndpointsAdded and EndpointsRemoved count endpoint changes over all
+	// tenants; MeanEndpointsRemoved is the average number of endpoints a
+	// tenant lost.
+	EndpointsAdded       int
+	EndpointsRemoved     int
+	MeanEndpointsRemoved float64
+}
+
+// DiffShards computes how the simple shuffle shards of the given tenants
+// change from the old to the next version of a lattice, see
+// SimpleShuffleShard. Each version is sharded with its own seed. Tenants
+// which cannot be sharded on a version are reported in their ShardChange,
+// and counted as Failed. Both versions must have the same dimensions, and
+// epc must not be negative; otherwise an error is returned.
+func DiffShards(old, next *Lattice, ids [][]byte, epc int) (*ShardChurn, error) {
+	if err := old.compatible(next.DimensionNames); err != nil {
+		return nil, fmt.Errorf("churn: %v", err)
+	} else if epc < 0 {
+		return nil, fmt.Errorf(
+			"churn: negative number of endpoints per cell: %d", epc,
+		)
+	}
+
+	var (
+		before, oldErrs = old.shardMany(ids, epc, 1)
+		after, newErrs  = next.shardMany(ids, epc, 1)
+		churn           = &ShardChurn{Tenants: len(ids)}
+	)
+
+	for i, id := range ids {
+		a, b := before[i], after[i]
+		c := ShardChange{ID: id}
+
+		if oldErrs[i] != nil {
+			a = newLattice(old.Seed, old.DimensionNames)
+			c.Err = fmt.Errorf(
+				"churn: unable to shard %q on the old lattice: %w",
+				id, oldErrs[i],
+			)
+		}
+		if newErrs[i] != nil {
+			b = newLattice(next.Seed, next.DimensionNames)
+			if c.Err == nil {
+				c.Err = fmt.Errorf(
+					"churn: unable to shard %q on the new lattice: %w",
+					id, newErrs[i],
+				)
+			}
+		}
+		if c.Err != nil {
+			churn.Failed++
+		}
+
+		c.AddedEndpoints = difference(b.GetAllEndpoints(), a.GetAllEndpoints())
+		c.RemovedEndpoints = difference(a.GetAllEndpoints(), b.GetAllEndpoints())
+		c.AddedCells = cellDifference(b, a)
+		c.RemovedCells = cellDifference(a, b)
+
+		if c.Moved() {
+			churn.Moved++
+		}
+		churn.EndpointsAdded += len(c.AddedEndpoints)
+		churn.EndpointsRemoved += len(c.RemovedEndpoints)
+		churn.Changes = append(churn.Changes, c)
+	}
+
+	if churn.Tenants > 0 {
+		churn.MovedFraction = float64(churn.Moved) / float64(churn.Tenants)
+		churn.MeanEndpointsRemoved =
+			float64(churn.EndpointsRemoved) / float64(churn.Tenants)
+	}
+
+	return churn, nil
+}
+
+// difference returns the elements of a which are not in b, sorted.
+func difference(a, b []string) []string {
+	var (
+		m = map[string]bool{}
+		d []string
+	)
+
+	for _, e := range b {
+		m[e] = true
+	}
+	for _, e := range a {
+		if !m[e] {
+			d = append(d, e)
+		}
+	}
+
+	sort.Strings(d)
+	return d
+}
+
+// cellDifference returns the cells of a which are not cells of b, ordered
+// by their sectors.
+func cellDifference(a, b *Lattice) [][]string {
+	var (
+		m = map[string]bool{}
+		d [][]string
+	)
+
+	for _, c := range b.GetAllCoordinates() {
+		m[strings.Join(c, seperator)] = true
+	}
+	for _, c := range a.GetAllCoordinates() {
+		if !m[strings.Join(c, seperator)] {
+			d = append(d, c)
+		}
+	}
+
+	return d
+}
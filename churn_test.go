@@ -0,0 +1,133 @@
+package shuffle_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestDiffShards checks the churn of shards when an endpoint is removed.
+func TestDiffShards(t *testing.T) {
+	var (
+		ids  = batchIDs(1000)
+		old  = batchLattice(t)
+		next = batchLattice(t)
+	)
+
+	next.EndpointsByCoordinate["x⚡️1"] = next.EndpointsByCoordinate["x⚡️1"][1:]
+
+	churn, err := shuffle.DiffShards(old, next, ids, 2)
+	if err != nil {
+		t.Fatalf("unable to diff the shards: %v", err)
+	}
+
+	if churn.Tenants != 1000 || len(churn.Changes) != 1000 {
+		t.Fatalf("illegal number of tenants: %d", churn.Tenants)
+	}
+
+	moved := 0
+	for i, c := range churn.Changes {
+		if string(c.ID) != string(ids[i]) {
+			t.Fatalf("changes are out of order: %s != %s", c.ID, ids[i])
+		}
+
+		if !c.Moved() {
+			continue
+		}
+		moved++
+
+		// The dimension values did not change, so neither do the cells.
+		if len(c.AddedCells) != 0 || len(c.RemovedCells) != 0 {
+			t.Fatalf("unexpected cell change: %+v", c)
+		}
+		if len(c.AddedEndpoints) != len(c.RemovedEndpoints) {
+			t.Fatalf("unexpected endpoint change: %+v", c)
+		}
+
+		a, _ := old.SimpleShuffleShard(c.ID, 2)
+		b, _ := next.SimpleShuffleShard(c.ID, 2)
+		for _, ep := range c.AddedEndpoints {
+			if contains(ep, a.GetAllEndpoints()) ||
+				!contains(ep, b.GetAllEndpoints()) {
+				t.Fatalf("illegal added endpoint %s: %+v", ep, c)
+			}
+		}
+		for _, ep := range c.RemovedEndpoints {
+			if !contains(ep, a.GetAllEndpoints()) ||
+				contains(ep, b.GetAllEndpoints()) {
+				t.Fatalf("illegal removed endpoint %s: %+v", ep, c)
+			}
+		}
+	}
+
+	if moved != churn.Moved || moved == 0 ||
+		churn.MovedFraction != float64(moved)/1000 ||
+		churn.EndpointsAdded != churn.EndpointsRemoved ||
+		churn.MeanEndpointsRemoved != float64(churn.EndpointsRemoved)/1000 {
+		t.Fatalf("illegal churn statistics: %+v", churn)
+	}
+
+	// The same lattice does not move anyone.
+	churn, err = shuffle.DiffShards(old, old, ids, 2)
+	if err != nil {
+		t.Fatalf("unable to diff the shards: %v", err)
+	}
+	if churn.Moved != 0 {
+		t.Fatalf("tenants moved without a change: %d", churn.Moved)
+	}
+}
+
+// TestDiffShardsErrors checks that tenants which cannot be sharded on the
+// new lattice are reported, rather than failing the whole diff.
+func TestDiffShardsErrors(t *testing.T) {
+	var (
+		ids  = batchIDs(1000)
+		old  = batchLattice(t)
+		next = batchLattice(t)
+	)
+
+	next.EndpointsByCoordinate["x⚡️1"] = next.EndpointsByCoordinate["x⚡️1"][:1]
+
+	churn, err := shuffle.DiffShards(old, next, ids, 2)
+	if err != nil {
+		t.Fatalf("unable to diff the shards: %v", err)
+	}
+
+	failed := 0
+	for _, c := range churn.Changes {
+		if c.Err == nil {
+			continue
+		}
+		failed++
+
+		if !errors.Is(c.Err, shuffle.ErrNotEnoughEndpoints) {
+			t.Fatalf("unexpected error: %v", c.Err)
+		}
+
+		// The tenant loses its whole shard.
+		a, _ := old.SimpleShuffleShard(c.ID, 2)
+		if !c.Moved() || len(c.AddedEndpoints) != 0 ||
+			len(c.RemovedEndpoints) != len(a.GetAllEndpoints()) {
+			t.Fatalf("illegal change of a failed tenant: %+v", c)
+		}
+	}
+
+	if failed == 0 || failed == 1000 || failed != churn.Failed ||
+		churn.Moved < failed {
+		t.Fatalf("illegal churn statistics: %d failed, %+v", failed, churn)
+	}
+
+	// Lattices of other dimensions, or a negative number of endpoints,
+	// fail the whole diff.
+	other, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	if _, err = shuffle.DiffShards(old, other, ids, 2); err == nil {
+		t.Fatalf("expected error for mismatched dimensions")
+	}
+	if _, err = shuffle.DiffShards(old, next, ids, -1); err == nil {
+		t.Fatalf("expected error for a negative number of endpoints")
+	}
+}
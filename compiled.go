@@ -0,0 +1,209 @@
+package shuffle
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// CompiledLattice is a read-only form of a Lattice, laid out to compute
+// simple shuffle shards without allocating. Dimension values are replaced
+// by their indices and every cell is addressed by a single integer. It is
+// safe for concurrent use, and is not affected by later changes to the
+// lattice it was compiled from.
+type CompiledLattice struct {
+	seed int64
+
+	// The sorted values of each dimension.
+	values [][]string
+
+	// Multipliers to turn value indices into a cell index: the index of
+	// a cell is the sum of the value index times the radix of each
+	// dimension.
+	radix []int
+
+	// Endpoints by cell index.
+	cells map[int][]string
+
+	// Number of cells in a shard, and the size of the largest cell.
+	shardCells int
+	maxCell    int
+
+	// Scratch space for computing shards, see compiledScratch.
+	scratch sync.Pool
+}
+
+// compiledScratch is the per-call working memory of ShardInto.
+type compiledScratch struct {
+	r    *rand.Rand
+	dims [][]int
+	eps  []string
+}
+
+// Compile creates the compiled form of a lattice. ShardInto on the result
+// picks the same endpoints as SimpleShuffleShard does on the lattice.
+func (l *Lattice) Compile() (*CompiledLattice, error) {
+	var (
+		c    *CompiledLattice
+		idx  []map[string]int
+		size int
+	)
+
+	c = &CompiledLattice{
+		seed:       l.Seed,
+		cells:      map[int][]string{},
+		shardCells: math.MaxInt32,
+	}
+
+	size = 1
+	for _, d := range l.GetDimensionNames() {
+		v := l.GetDimensionValues(d)
+		if len(v) < c.shardCells {
+			c.shardCells = len(v)
+		}
+
+		m := map[string]int{}
+		for i := range v {
+			m[v[i]] = i
+		}
+
+		c.values = append(c.values, v)
+		c.radix = append(c.radix, size)
+		idx = append(idx, m)
+
+		if len(v) > 0 && size > math.MaxInt/len(v) {
+			return nil, fmt.Errorf("lattice: too many cells to compile")
+		} else if len(v) > 0 {
+			size *= len(v)
+		}
+	}
+
+	// One dimensional lattices select end-points from each cell.
+	if len(c.values) == 1 {
+		c.shardCells = len(c.values[0])
+	}
+
+	x := l.index()
+	for _, lc := range x.cells {
+		cell := 0
+		for i, id := range lc.sector {
+			cell += idx[i][x.values[i][id]] * c.radix[i]
+		}
+
+		c.cells[cell] = append([]string{}, x.endpoints(lc)...)
+		if len(x.endpoints(lc)) > c.maxCell {
+			c.maxCell = len(x.endpoints(lc))
+		}
+	}
+
+	c.scratch.New = func() interface{} {
+		s := &compiledScratch{
+			r:   rand.New(rand.NewSource(0)),
+			eps: make([]string, c.maxCell),
+		}
+		for _, v := range c.values {
+			s.dims = append(s.dims, make([]int, len(v)))
+		}
+		return s
+	}
+
+	return c, nil
+}
+
+// ShardSize returns the number of endpoints in a shard with epc endpoints
+// per cell, which is the size dst needs for ShardInto.
+func (c *CompiledLattice) ShardSize(epc int) int {
+	return c.shardCells * epc
+}
+
+// ShardInto computes the shuffle shard for the given identification and
+// required number of endpoints per cell, like SimpleShuffleShard, and
+// writes its endpoints into dst in the order they were picked, one cell
+// after the other. It returns the number of endpoints written. No memory
+// is allocated, unless an error is returned.
+func (c *CompiledLattice) ShardInto(dst []string, id []byte, epc int) (
+	int, error,
+) {
+	var (
+		s    *compiledScratch
+		n    int
+		cell int
+		eps  []string
+	)
+
+	if epc < 0 {
+		return 0, fmt.Errorf(
+			"shard: negative number of endpoints per cell: %d", epc,
+		)
+	} else if len(dst) < c.ShardSize(epc) {
+		return 0, fmt.Errorf(
+			"shard: destination too small: %d < %d",
+			len(dst), c.ShardSize(epc),
+		)
+	}
+
+	s = c.scratch.Get().(*compiledScratch)
+	defer c.scratch.Put(s)
+
+	// Seed the random generator, as SimpleShuffleShard does.
+	s.r.Seed(
+		c.seed * int64(murmur3.Sum64WithSeed(id, uint32(c.seed))) * 42,
+	)
+
+	// Shuffle the order of the values in each dimension.
+	for _, d := range s.dims {
+		for j := range d {
+			d[j] = j
+		}
+		s.r.Shuffle(len(d), func(x, y int) {
+			d[x], d[y] = d[y], d[x]
+		})
+	}
+
+	// Pick the cells along the shuffled diagonal.
+	for i := 0; i < c.shardCells; i++ {
+		cell = 0
+		for j, d := range s.dims {
+			cell += d[i] * c.radix[j]
+		}
+
+		eps = c.cells[cell]
+		if len(eps) <= 0 {
+			return 0, ErrNoEndpoints
+		} else if len(eps) < epc {
+			return 0, fmt.Errorf(
+				"%w in cell: %d < %d",
+				ErrNotEnoughEndpoints, len(eps), epc,
+			)
+		}
+
+		eps = s.eps[:copy(s.eps, eps)]
+		s.r.Shuffle(len(eps), func(x, y int) {
+			eps[x], eps[y] = eps[y], eps[x]
+		})
+
+		// Skip the endpoints already picked from other cells. Shards are
+		// small, a linear scan is cheaper than a set.
+		picked := n
+		for _, e := range eps {
+			if n-picked == epc {
+				break
+			} else if indexOf(dst[:picked], e) < 0 {
+				dst[n] = e
+				n++
+			}
+		}
+
+		if n-picked < epc {
+			return 0, fmt.Errorf(
+				"%w in cell: %d distinct < %d",
+				ErrNotEnoughEndpoints, n-picked, epc,
+			)
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,117 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestCompiledLatticeShardInto checks that ShardInto picks the same
+// endpoints as SimpleShuffleShard.
+func TestCompiledLatticeShardInto(t *testing.T) {
+	one, err := shuffle.NewLatticeWithSeed(42, []string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+	one.AddEndpointsForSector([]string{"x"}, []string{"a", "b", "c", "d"})
+	one.AddEndpointsForSector([]string{"y"}, []string{"e", "f", "g", "h"})
+
+	asym, err := shuffle.NewLatticeWithSeed(42, []string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+	for _, az := range []string{"x", "y"} {
+		for _, v := range []string{"1", "2", "3"} {
+			asym.AddEndpointsForSector(
+				[]string{az, v},
+				[]string{az + v + "-a", az + v + "-b", az + v + "-c"},
+			)
+		}
+	}
+
+	for _, lat := range []*shuffle.Lattice{one, asym, batchLattice(t)} {
+		c, err := lat.Compile()
+		if err != nil {
+			t.Fatalf("unable to compile the lattice: %v", err)
+		}
+
+		dst := make([]string, c.ShardSize(2))
+		for i := 0; i < 1000; i++ {
+			id := []byte(fmt.Sprintf("%d", i))
+
+			n, err := c.ShardInto(dst, id, 2)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			shd, err := lat.SimpleShuffleShard(id, 2)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			got := append([]string{}, dst[:n]...)
+			sort.Strings(got)
+			if fmt.Sprint(got) != fmt.Sprint(shd.GetAllEndpoints()) {
+				t.Fatalf(
+					"compiled shard of %s differs: expected %v, but got: %v",
+					id, shd.GetAllEndpoints(), got,
+				)
+			}
+		}
+
+		if _, err := c.ShardInto(dst[:1], []byte("foo"), 2); err == nil {
+			t.Fatalf("expected error for a small destination")
+		}
+		if _, err := c.ShardInto(dst, []byte("foo"), -1); err == nil {
+			t.Fatalf("expected error for a negative number of endpoints")
+		}
+	}
+}
+
+// TestCompiledLatticeAllocs checks that ShardInto does not allocate.
+func TestCompiledLatticeAllocs(t *testing.T) {
+	if race {
+		t.Skip("the race detector makes sync.Pool allocate")
+	}
+
+	c, err := batchLattice(t).Compile()
+	if err != nil {
+		t.Fatalf("unable to compile the lattice: %v", err)
+	}
+
+	id := []byte("tenant")
+	dst := make([]string, c.ShardSize(2))
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		if _, err := c.ShardInto(dst, id, 2); err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, but got: %v", allocs)
+	}
+}
+
+// BenchmarkCompiledLatticeShardInto shards a batch of tenants with a
+// compiled lattice.
+func BenchmarkCompiledLatticeShardInto(b *testing.B) {
+	c, err := batchLattice(b).Compile()
+	if err != nil {
+		b.Fatalf("unable to compile the lattice: %v", err)
+	}
+
+	ids := batchIDs(1000)
+	dst := make([]string, c.ShardSize(2))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for _, id := range ids {
+			if _, err := c.ShardInto(dst, id, 2); err != nil {
+				b.Fatalf("unable to shard the lattice: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package shuffle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Coordinate is a sector given as the value of each dimension by name, for
+// example: Coordinate{"az": "us-x", "version": "v42"}. Unlike a sector, it
+// does not depend on the order of the dimensions of the lattice.
+type Coordinate map[string]string
+
+// String formats the coordinate with its dimensions sorted.
+func (c Coordinate) String() string {
+	var dims []string
+	for d := range c {
+		dims = append(dims, d)
+	}
+	sort.Strings(dims)
+
+	for i, d := range dims {
+		dims[i] = d + "=" + c[d]
+	}
+
+	return "{" + strings.Join(dims, ", ") + "}"
+}
+
+// Sector returns the sector of a coordinate, with the values in the order
+// of the dimensions of the lattice. The coordinate must have a value for
+// every dimension of the lattice, and no other.
+func (l *Lattice) Sector(c Coordinate) ([]string, error) {
+	if len(c) != len(l.DimensionNames) {
+		return nil, fmt.Errorf(
+			"lattice: coordinate %v does not match the dimensions %v",
+			c, l.DimensionNames,
+		)
+	}
+
+	sec := make([]string, len(l.DimensionNames))
+	for i, d := range l.DimensionNames {
+		v, ok := c[d]
+		if !ok {
+			return nil, fmt.Errorf(
+				"lattice: coordinate %v has no value for dimension %q", c, d,
+			)
+		}
+		sec[i] = v
+	}
+
+	return sec, nil
+}
+
+// Coordinate returns the coordinate of a sector of the lattice.
+func (l *Lattice) Coordinate(sec []string) (Coordinate, error) {
+	if len(sec) != len(l.DimensionNames) {
+		return nil, fmt.Errorf(
+			"lattice: mismatch between dimensions of the lattice and sector",
+		)
+	}
+
+	c := make(Coordinate, len(sec))
+	for i, d := range l.DimensionNames {
+		c[d] = sec[i]
+	}
+
+	return c, nil
+}
+
+// AddEndpointsForCoordinate is AddEndpointsForSector, for the sector of a
+// coordinate.
+func (l *Lattice) AddEndpointsForCoordinate(c Coordinate, ep []string) error {
+	sec, err := l.Sector(c)
+	if err != nil {
+		return err
+	}
+
+	return l.AddEndpointsForSector(sec, ep)
+}
+
+// GetEndpointsForCoordinate is GetEndpointsForSector, for the sector of a
+// coordinate.
+func (l *Lattice) GetEndpointsForCoordinate(c Coordinate) ([]string, error) {
+	sec, err := l.Sector(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.GetEndpointsForSector(sec)
+}
+
+// Dimension is a handle on a dimension of a lattice, which knows where its
+// values are in the sectors of the lattice. Handles become stale if the
+// dimensions of the lattice are modified.
+type Dimension struct {
+	l *Lattice
+	i int
+}
+
+// Dimension returns the handle of a dimension of the lattice, if it has
+// the dimension.
+func (l *Lattice) Dimension(name string) (Dimension, bool) {
+	i := indexOf(l.DimensionNames, name)
+	if i < 0 {
+		return Dimension{}, false
+	}
+
+	return Dimension{l, i}, true
+}
+
+// Name returns the name of the dimension.
+func (d Dimension) Name() string {
+	return d.l.DimensionNames[d.i]
+}
+
+// Index returns the position of the values of the dimension in sectors.
+func (d Dimension) Index() int {
+	return d.i
+}
+
+// Values returns the values of the dimension, see GetDimensionValues.
+func (d Dimension) Values() []string {
+	return d.l.GetDimensionValues(d.Name())
+}
+
+// Of returns the value of the dimension in a sector of the lattice.
+func (d Dimension) Of(sec []string) string {
+	return sec[d.i]
+}
@@ -0,0 +1,96 @@
+package shuffle_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestCoordinate tests adding and fetching endpoints by coordinate.
+func TestCoordinate(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"version", "az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	err = l.AddEndpointsForCoordinate(
+		shuffle.Coordinate{"az": "us-x", "version": "v42"},
+		[]string{"foo", "bar"},
+	)
+	if err != nil {
+		t.Fatalf("unable to add endpoints: %v", err)
+	}
+
+	// The sector is in the order of the dimensions of the lattice.
+	e, err := l.GetEndpointsForSector([]string{"us-x", "v42"})
+	if err != nil {
+		t.Fatalf("unable to fetch endpoints: %v", err)
+	}
+	if strings.Join(e, ", ") != "bar, foo" {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+
+	e, err = l.GetEndpointsForCoordinate(
+		shuffle.Coordinate{"version": "v42", "az": "us-x"},
+	)
+	if err != nil || strings.Join(e, ", ") != "bar, foo" {
+		t.Fatalf("illegal endpoints returned: %v, %v", e, err)
+	}
+
+	for _, c := range []shuffle.Coordinate{
+		{"az": "us-x"},
+		{"az": "us-x", "go-lang": "1.1"},
+		{"az": "us-x", "version": "v42", "go-lang": "1.1"},
+	} {
+		if err = l.AddEndpointsForCoordinate(c, []string{"baz"}); err == nil {
+			t.Fatalf("expected an error for coordinate %v", c)
+		}
+		if _, err = l.GetEndpointsForCoordinate(c); err == nil {
+			t.Fatalf("expected an error for coordinate %v", c)
+		}
+	}
+
+	c, err := l.Coordinate([]string{"us-x", "v42"})
+	if err != nil {
+		t.Fatalf("unable to get the coordinate: %v", err)
+	}
+	if c.String() != "{az=us-x, version=v42}" {
+		t.Fatalf("illegal coordinate: %v", c)
+	}
+	if _, err = l.Coordinate([]string{"us-x"}); err == nil {
+		t.Fatalf("expected an error for a short sector")
+	}
+}
+
+// TestDimension tests dimension handles.
+func TestDimension(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"version", "az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	l.AddEndpointsForCoordinate(
+		shuffle.Coordinate{"az": "us-x", "version": "v42"}, []string{"foo"},
+	)
+	l.AddEndpointsForCoordinate(
+		shuffle.Coordinate{"az": "us-y", "version": "v42"}, []string{"bar"},
+	)
+
+	if _, ok := l.Dimension("go-lang"); ok {
+		t.Fatalf("unknown dimension has a handle")
+	}
+
+	az, ok := l.Dimension("az")
+	if !ok {
+		t.Fatalf("unable to get the handle of a dimension")
+	}
+	if az.Name() != "az" || strings.Join(az.Values(), ", ") != "us-x, us-y" {
+		t.Fatalf("illegal dimension: %s %v", az.Name(), az.Values())
+	}
+
+	for _, sec := range l.GetAllCoordinates() {
+		if az.Of(sec) != sec[az.Index()] || !strings.HasPrefix(az.Of(sec), "us-") {
+			t.Fatalf("illegal value of dimension in %v: %s", sec, az.Of(sec))
+		}
+	}
+}
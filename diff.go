@@ -0,0 +1,240 @@
+package shuffle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// LatticeDiff is the difference between two lattices with the same
+// dimensions: the changes which turn one into the other.
+type LatticeDiff struct {
+	// DimensionNames are the dimensions of both lattices.
+	DimensionNames []string
+
+	// Sectors whose cell only exists in the other lattice, or only in
+	// this one, ordered by sector.
+	AddedSectors   [][]string
+	RemovedSectors [][]string
+
+	// Endpoints to add to and to remove from each sector, ordered by
+	// sector. Added and removed sectors are included.
+	AddedEndpoints   []SectorEndpoints
+	RemovedEndpoints []SectorEndpoints
+}
+
+// SectorEndpoints is a set of endpoints of a sector.
+type SectorEndpoints struct {
+	Sector    []string
+	Endpoints []string
+}
+
+// compatible checks that the given dimensions are the ones of the lattice,
+// in the same order, so that their sectors are alike.
+func (l *Lattice) compatible(dims []string) error {
+	if strings.Join(dims, seperator) !=
+		strings.Join(l.DimensionNames, seperator) {
+		return fmt.Errorf(
+			"lattice: mismatch between dimensions %v and %v",
+			l.DimensionNames, dims,
+		)
+	}
+
+	return nil
+}
+
+// Diff computes the changes which turn the lattice into the other lattice.
+// Both lattices must have the same dimensions.
+func (l *Lattice) Diff(other *Lattice) (*LatticeDiff, error) {
+	var (
+		d     *LatticeDiff
+		x, y  = l.index(), other.index()
+		mine  = map[string]*latticeCell{}
+		their = map[string]*latticeCell{}
+
+		err error
+	)
+
+	if err = l.compatible(other.DimensionNames); err != nil {
+		return nil, err
+	}
+
+	d = &LatticeDiff{
+		DimensionNames: append([]string{}, l.DimensionNames...),
+	}
+
+	for _, c := range x.cells {
+		mine[strings.Join(x.coordinates(c), seperator)] = c
+	}
+	for _, c := range y.cells {
+		their[strings.Join(y.coordinates(c), seperator)] = c
+	}
+
+	// Cells of the other lattice, new or changed.
+	for _, c := range y.cells {
+		sec := y.coordinates(c)
+
+		var eps []string
+		if m, ok := mine[strings.Join(sec, seperator)]; ok {
+			eps = x.endpoints(m)
+		} else {
+			d.AddedSectors = append(d.AddedSectors, sec)
+		}
+
+		if added := difference(y.endpoints(c), eps); len(added) > 0 {
+			d.AddedEndpoints = append(
+				d.AddedEndpoints, SectorEndpoints{sec, added},
+			)
+		}
+		if removed := difference(eps, y.endpoints(c)); len(removed) > 0 {
+			d.RemovedEndpoints = append(
+				d.RemovedEndpoints, SectorEndpoints{sec, removed},
+			)
+		}
+	}
+
+	// Cells which are gone.
+	for _, c := range x.cells {
+		sec := x.coordinates(c)
+		if _, ok := their[strings.Join(sec, seperator)]; ok {
+			continue
+		}
+
+		d.RemovedSectors = append(d.RemovedSectors, sec)
+		d.RemovedEndpoints = append(
+			d.RemovedEndpoints,
+			SectorEndpoints{sec, append([]string{}, x.endpoints(c)...)},
+		)
+	}
+
+	return d, nil
+}
+
+// Merge adds all the endpoints of the other lattice to the lattice. Both
+// lattices must have the same dimensions. The endpoints are added to a copy
+// of the lattice, which only replaces the lattice if every cell could be
+// added, so the lattices are merged entirely or not at all.
+func (l *Lattice) Merge(other *Lattice) error {
+	if err := l.compatible(other.DimensionNames); err != nil {
+		return err
+	}
+
+	var (
+		c = l.Clone()
+		y = other.index()
+	)
+	for _, o := range y.cells {
+		err := c.AddEndpointsForSector(y.coordinates(o), y.endpoints(o))
+		if err != nil {
+			return err
+		}
+	}
+	l.replace(c)
+
+	return nil
+}
+
+// Apply applies a diff to the lattice: the removed endpoints are removed,
+// and the added endpoints are added. Cells left without endpoints are
+// removed, along with the dimension values no cell refers to anymore. The
+// diff is checked before any change is made: its dimensions must be the
+// ones of the lattice, and removed endpoints must be in the lattice. It is
+// then applied to a copy of the lattice, which only replaces the lattice if
+// every change could be made, so a diff is applied entirely or not at all.
+func (l *Lattice) Apply(d *LatticeDiff) error {
+	var (
+		x   = l.index()
+		err error
+	)
+
+	if err = l.compatible(d.DimensionNames); err != nil {
+		return err
+	}
+
+	for _, r := range d.RemovedEndpoints {
+		if len(r.Sector) != len(l.DimensionNames) {
+			return fmt.Errorf(
+				"lattice: mismatch between dimensions of the lattice " +
+					"and sector",
+			)
+		}
+
+		var eps []string
+		if c := x.find(r.Sector); c != nil {
+			eps = x.endpoints(c)
+		}
+		if missing := difference(r.Endpoints, eps); len(missing) > 0 {
+			return fmt.Errorf(
+				"lattice: endpoints %v are not in sector %v",
+				missing, r.Sector,
+			)
+		}
+	}
+	for _, a := range d.AddedEndpoints {
+		if len(a.Sector) != len(l.DimensionNames) {
+			return fmt.Errorf(
+				"lattice: mismatch between dimensions of the lattice " +
+					"and sector",
+			)
+		}
+	}
+
+	c := l.Clone()
+	for _, r := range d.RemovedEndpoints {
+		c.removeEndpointsFromSector(r.Sector, r.Endpoints)
+	}
+	for _, a := range d.AddedEndpoints {
+		if err = c.AddEndpointsForSector(a.Sector, a.Endpoints); err != nil {
+			return err
+		}
+	}
+
+	l.replace(c)
+
+	return nil
+}
+
+// replace replaces the cells of the lattice with those of a copy of it.
+func (l *Lattice) replace(c *Lattice) {
+	l.ValuesByDimension = c.ValuesByDimension
+	l.EndpointsByCoordinate = c.EndpointsByCoordinate
+	l.idx.Store(c.index())
+}
+
+// removeEndpointsFromSector removes endpoints from the cell of a sector.
+// If the cell is left empty it is removed, and so are the values of its
+// sector which are not used by any other cell.
+func (l *Lattice) removeEndpointsFromSector(sec, ep []string) {
+	k := strings.Join(sec, seperator)
+
+	eps, ok := l.EndpointsByCoordinate[k]
+	if !ok {
+		return
+	}
+
+	x := l.index()
+	eps = difference(eps, ep)
+	if len(eps) > 0 {
+		l.EndpointsByCoordinate[k] = eps
+		return
+	}
+
+	delete(l.EndpointsByCoordinate, k)
+	x.remove(sec)
+
+	// Drop the values of the sector no other cell uses.
+	for i, d := range l.DimensionNames {
+		used := false
+		for _, c := range x.cells {
+			if x.values[i][c.sector[i]] == sec[i] {
+				used = true
+				break
+			}
+		}
+
+		if !used {
+			l.ValuesByDimension[d] = difference(
+				l.ValuesByDimension[d], []string{sec[i]},
+			)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package shuffle_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// diffLattices creates two versions of a lattice: in the second one, a cell
+// is gone, another is new, and a third has one endpoint replaced.
+func diffLattices(t *testing.T) (*shuffle.Lattice, *shuffle.Lattice) {
+	a, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	a.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"a", "b"})
+	a.AddEndpointsForSector([]string{"us-x", "1.2"}, []string{"c", "d"})
+	a.AddEndpointsForSector([]string{"us-y", "1.1"}, []string{"e", "f"})
+
+	b, err := shuffle.NewLattice([]string{"go-lang", "az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	b.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"a", "b"})
+	b.AddEndpointsForSector([]string{"us-x", "1.2"}, []string{"c", "g"})
+	b.AddEndpointsForSector([]string{"us-z", "1.3"}, []string{"h"})
+
+	return a, b
+}
+
+// TestLatticeDiff checks the difference between two lattices.
+func TestLatticeDiff(t *testing.T) {
+	a, b := diffLattices(t)
+
+	d, err := a.Diff(b)
+	if err != nil {
+		t.Fatalf("unable to diff the lattices: %v", err)
+	}
+
+	expected := &shuffle.LatticeDiff{
+		DimensionNames: []string{"az", "go-lang"},
+		AddedSectors:   [][]string{{"us-z", "1.3"}},
+		RemovedSectors: [][]string{{"us-y", "1.1"}},
+		AddedEndpoints: []shuffle.SectorEndpoints{
+			{[]string{"us-x", "1.2"}, []string{"g"}},
+			{[]string{"us-z", "1.3"}, []string{"h"}},
+		},
+		RemovedEndpoints: []shuffle.SectorEndpoints{
+			{[]string{"us-x", "1.2"}, []string{"d"}},
+			{[]string{"us-y", "1.1"}, []string{"e", "f"}},
+		},
+	}
+	if !reflect.DeepEqual(d, expected) {
+		t.Fatalf("illegal diff returned: expected %+v, but got %+v", expected, d)
+	}
+
+	other, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	if _, err = a.Diff(other); err == nil {
+		t.Fatalf("expected error for mismatched dimensions")
+	}
+}
+
+// TestLatticeApply checks that applying a diff turns a lattice into the
+// other one.
+func TestLatticeApply(t *testing.T) {
+	a, b := diffLattices(t)
+
+	d, err := a.Diff(b)
+	if err != nil {
+		t.Fatalf("unable to diff the lattices: %v", err)
+	}
+
+	if err = a.Apply(d); err != nil {
+		t.Fatalf("unable to apply the diff: %v", err)
+	}
+
+	if !reflect.DeepEqual(a.GetAllCoordinates(), b.GetAllCoordinates()) ||
+		!reflect.DeepEqual(a.GetAllEndpoints(), b.GetAllEndpoints()) ||
+		!reflect.DeepEqual(a.GetDimensionValues("az"), b.GetDimensionValues("az")) ||
+		!reflect.DeepEqual(a.GetDimensionValues("go-lang"), b.GetDimensionValues("go-lang")) {
+		t.Fatalf(
+			"lattices differ after applying the diff: %v != %v",
+			a.EndpointsByCoordinate, b.EndpointsByCoordinate,
+		)
+	}
+
+	// Removing endpoints which are not there fails, without changes.
+	err = a.Apply(&shuffle.LatticeDiff{
+		DimensionNames: []string{"az", "go-lang"},
+		AddedEndpoints: []shuffle.SectorEndpoints{
+			{[]string{"us-x", "1.1"}, []string{"x"}},
+		},
+		RemovedEndpoints: []shuffle.SectorEndpoints{
+			{[]string{"us-y", "1.1"}, []string{"e"}},
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error for removing missing endpoints")
+	}
+	if contains("x", a.GetAllEndpoints()) {
+		t.Fatalf("failed diff was partially applied")
+	}
+
+	// Additions which the lattice rejects fail after the removals were
+	// made, without changes either.
+	a.Duplicates = shuffle.RejectDuplicates
+	for name, added := range map[string]shuffle.SectorEndpoints{
+		"separator": {[]string{"us-⚡️", "1.1"}, []string{"x"}},
+		"duplicate": {[]string{"us-x", "1.1"}, []string{"c"}},
+	} {
+		err = a.Apply(&shuffle.LatticeDiff{
+			DimensionNames: []string{"az", "go-lang"},
+			AddedEndpoints: []shuffle.SectorEndpoints{added},
+			RemovedEndpoints: []shuffle.SectorEndpoints{
+				{[]string{"us-z", "1.3"}, []string{"h"}},
+			},
+		})
+		if err == nil {
+			t.Fatalf("%s: expected error for the added endpoints", name)
+		}
+		if !contains("h", a.GetAllEndpoints()) ||
+			!reflect.DeepEqual(a.GetAllCoordinates(), b.GetAllCoordinates()) {
+			t.Fatalf("%s: failed diff was partially applied", name)
+		}
+	}
+}
+
+// TestLatticeMerge checks the merge of two lattices.
+func TestLatticeMerge(t *testing.T) {
+	a, b := diffLattices(t)
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("unable to merge the lattices: %v", err)
+	}
+
+	if !reflect.DeepEqual(
+		a.GetAllEndpoints(),
+		[]string{"a", "b", "c", "d", "e", "f", "g", "h"},
+	) {
+		t.Fatalf("illegal endpoints after merge: %v", a.GetAllEndpoints())
+	}
+
+	e, _ := a.GetEndpointsForSector([]string{"us-x", "1.2"})
+	if !reflect.DeepEqual(e, []string{"c", "d", "g"}) {
+		t.Fatalf("illegal endpoints after merge: %v", e)
+	}
+
+	other, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	if err = a.Merge(other); err == nil {
+		t.Fatalf("expected error for mismatched dimensions")
+	}
+
+	// A cell which the lattice rejects, after another was added, fails
+	// without changes.
+	c, d := diffLattices(t)
+	c.Duplicates = shuffle.RejectDuplicates
+	d.AddEndpointsForSector([]string{"us-w", "1.1"}, []string{"w"})
+	d.AddEndpointsForSector([]string{"us-z", "1.3"}, []string{"e"})
+
+	want := c.Clone()
+	if err = c.Merge(d); err == nil {
+		t.Fatalf("expected error for duplicate endpoints")
+	}
+	if contains("w", c.GetAllEndpoints()) ||
+		!reflect.DeepEqual(c.GetAllCoordinates(), want.GetAllCoordinates()) ||
+		!reflect.DeepEqual(c.ValuesByDimension, want.ValuesByDimension) {
+		t.Fatalf("failed merge was partially applied")
+	}
+}
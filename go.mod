@@ -1,10 +1,9 @@
 module github.com/clickyotomy/go-shuffle-shard
 
-go 1.16
+go 1.18
 
 require (
-	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/getlantern/deepcopy v0.0.0-20160317154340-7f45deb8130a
 	github.com/mxschmitt/golang-combinations v1.1.0
 	github.com/spaolacci/murmur3 v1.1.0
+	gopkg.in/yaml.v3 v3.0.1
 )
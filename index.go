@@ -0,0 +1,358 @@
+package shuffle
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// latticeIndex is the internal representation of the cells of a lattice.
+// The values of each dimension are interned as integers, and the cells are
+// kept in a sparse array, looked up by a hash of the value indices of their
+// sector. Lookups with a sector neither join nor split strings. Endpoints
+// are not copied: cells refer to them by their key in EndpointsByCoordinate,
+// which stays the source of truth.
+type latticeIndex struct {
+	// Value index by value, and value by value index, per dimension.
+	ids    []map[string]int
+	values [][]string
+
+	// Cells, in the order of their sectors (see cellLess).
+	cells []*latticeCell
+
+	// Cells by the hash of their sector.
+	lookup map[uint64][]*latticeCell
+
+	// The fields the index reflects: the map of the endpoints, its keys
+	// which were left out as malformed, and the dimensions; see synced.
+	eps  map[string][]string
+	bad  []string
+	dims []string
+}
+
+// latticeCell is a cell of a lattice, with its sector as value indices, and
+// its key in EndpointsByCoordinate.
+type latticeCell struct {
+	sector []int
+	key    string
+}
+
+// newLatticeIndex creates an empty index for the given number of dimensions.
+func newLatticeIndex(dims int) *latticeIndex {
+	x := &latticeIndex{
+		ids:    make([]map[string]int, dims),
+		values: make([][]string, dims),
+		lookup: map[uint64][]*latticeCell{},
+	}
+
+	for i := range x.ids {
+		x.ids[i] = map[string]int{}
+	}
+
+	return x
+}
+
+// hashSector hashes the value indices of a sector (FNV-1a).
+func hashSector(sec []int) uint64 {
+	h := uint64(14695981039346656037)
+	for _, v := range sec {
+		h ^= uint64(v)
+		h *= 1099511628211
+	}
+
+	return h
+}
+
+// stamp records the fields of the lattice the index reflects.
+func (x *latticeIndex) stamp(l *Lattice) {
+	x.eps = l.EndpointsByCoordinate
+	x.dims = append([]string{}, l.DimensionNames...)
+}
+
+// endpoints returns the endpoints of a cell.
+func (x *latticeIndex) endpoints(c *latticeCell) []string {
+	return x.eps[c.key]
+}
+
+// fresh tells whether the index is of the same map of endpoints, with as
+// many keys, and of the same dimensions as the lattice. It is cheap, but
+// sectors both removed and added directly go unnoticed; see synced.
+func (x *latticeIndex) fresh(l *Lattice) bool {
+	if reflect.ValueOf(l.EndpointsByCoordinate).Pointer() !=
+		reflect.ValueOf(x.eps).Pointer() ||
+		len(l.EndpointsByCoordinate) != len(x.cells)+len(x.bad) ||
+		len(l.DimensionNames) != len(x.dims) {
+		return false
+	}
+
+	for i, d := range l.DimensionNames {
+		if x.dims[i] != d {
+			return false
+		}
+	}
+
+	return true
+}
+
+// synced tells whether the index has exactly the sectors of the lattice:
+// it is fresh, and every key it knows of is still in the map, which then
+// has no other keys.
+func (x *latticeIndex) synced(l *Lattice) bool {
+	if !x.fresh(l) {
+		return false
+	}
+
+	for _, c := range x.cells {
+		if _, ok := x.eps[c.key]; !ok {
+			return false
+		}
+	}
+	for _, k := range x.bad {
+		if _, ok := x.eps[k]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// find returns the cell of a sector, or nil if there is no such cell.
+func (x *latticeIndex) find(sec []string) *latticeCell {
+	var (
+		h  = uint64(14695981039346656037)
+		id int
+		ok bool
+	)
+
+	if len(sec) != len(x.ids) {
+		return nil
+	}
+
+	// Same as hashSector, without building the slice of indices.
+	for i, v := range sec {
+		if id, ok = x.ids[i][v]; !ok {
+			return nil
+		}
+		h ^= uint64(id)
+		h *= 1099511628211
+	}
+
+	for _, c := range x.lookup[h] {
+		if x.matches(c, sec) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// matches tells whether a cell is the cell of a sector.
+func (x *latticeIndex) matches(c *latticeCell, sec []string) bool {
+	for i, id := range c.sector {
+		if x.values[i][id] != sec[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// coordinates returns the sector of a cell as dimension values.
+func (x *latticeIndex) coordinates(c *latticeCell) []string {
+	sec := make([]string, len(c.sector))
+	for i, id := range c.sector {
+		sec[i] = x.values[i][id]
+	}
+
+	return sec
+}
+
+// cellLess orders cells by their sectors, comparing the values of each
+// dimension in turn.
+func (x *latticeIndex) cellLess(a, b *latticeCell) bool {
+	for i := range a.sector {
+		va, vb := x.values[i][a.sector[i]], x.values[i][b.sector[i]]
+		if va != vb {
+			return va < vb
+		}
+	}
+
+	return false
+}
+
+// set creates the cell of a sector, if there is none; its endpoints must be
+// in the map under the given key.
+func (x *latticeIndex) set(sec []string, key string) {
+	// The sector does not fit the index, see Reindex.
+	if len(sec) != len(x.ids) {
+		return
+	}
+
+	if c := x.find(sec); c != nil {
+		return
+	}
+
+	c := &latticeCell{sector: make([]int, len(sec)), key: key}
+	for i, v := range sec {
+		id, ok := x.ids[i][v]
+		if !ok {
+			id = len(x.values[i])
+			x.ids[i][v] = id
+			x.values[i] = append(x.values[i], v)
+		}
+		c.sector[i] = id
+	}
+
+	h := hashSector(c.sector)
+	x.lookup[h] = append(x.lookup[h], c)
+
+	// Keep the cells ordered, so that reads never need to sort.
+	i := sort.Search(len(x.cells), func(i int) bool {
+		return x.cellLess(c, x.cells[i])
+	})
+	x.cells = append(x.cells, nil)
+	copy(x.cells[i+1:], x.cells[i:])
+	x.cells[i] = c
+}
+
+// clone copies the index for a copy of its lattice, whose endpoints the
+// cells then refer to.
+func (x *latticeIndex) clone(l *Lattice) *latticeIndex {
+	y := &latticeIndex{
+		ids:    make([]map[string]int, len(x.ids)),
+		values: make([][]string, len(x.values)),
+		cells:  make([]*latticeCell, len(x.cells)),
+		lookup: make(map[uint64][]*latticeCell, len(x.lookup)),
+		bad:    append([]string{}, x.bad...),
+	}
+	y.stamp(l)
+
+	for i := range x.ids {
+		y.ids[i] = make(map[string]int, len(x.ids[i]))
+		for v, id := range x.ids[i] {
+			y.ids[i][v] = id
+		}
+		y.values[i] = append([]string{}, x.values[i]...)
+	}
+
+	for i, c := range x.cells {
+		y.cells[i] = &latticeCell{
+			sector: append([]int{}, c.sector...),
+			key:    c.key,
+		}
+
+		h := hashSector(c.sector)
+		y.lookup[h] = append(y.lookup[h], y.cells[i])
+	}
+
+	return y
+}
+
+// remove removes the cell of a sector, if there is one. Interned values
+// are kept, even if no cell refers to them anymore.
+func (x *latticeIndex) remove(sec []string) {
+	c := x.find(sec)
+	if c == nil {
+		return
+	}
+
+	h := hashSector(c.sector)
+	for i := range x.lookup[h] {
+		if x.lookup[h][i] == c {
+			x.lookup[h] = append(x.lookup[h][:i], x.lookup[h][i+1:]...)
+			break
+		}
+	}
+	if len(x.lookup[h]) == 0 {
+		delete(x.lookup, h)
+	}
+
+	for i := range x.cells {
+		if x.cells[i] == c {
+			x.cells = append(x.cells[:i], x.cells[i+1:]...)
+			break
+		}
+	}
+}
+
+// buildIndex creates the index of a lattice from its exported fields.
+// Keys of EndpointsByCoordinate which do not have one value per dimension
+// are reported, and left out of the index.
+func buildIndex(l *Lattice) (*latticeIndex, error) {
+	var (
+		x    = newLatticeIndex(len(l.DimensionNames))
+		keys []string
+		bad  []string
+	)
+
+	for k := range l.EndpointsByCoordinate {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sec := strings.Split(k, seperator)
+		if len(sec) != len(l.DimensionNames) {
+			x.bad = append(x.bad, k)
+			bad = append(bad, fmt.Sprintf("%q", k))
+			continue
+		}
+		x.set(sec, k)
+	}
+	x.stamp(l)
+
+	if len(bad) > 0 {
+		return x, fmt.Errorf(
+			"lattice: sectors do not match the dimensions of the "+
+				"lattice: %s", strings.Join(bad, ", "),
+		)
+	}
+
+	return x, nil
+}
+
+// cached returns the index kept by the lattice, if it has one.
+func (l *Lattice) cached() *latticeIndex {
+	x, _ := l.idx.Load().(*latticeIndex)
+	return x
+}
+
+// index returns the index of the lattice, with exactly its sectors. It is
+// built from the exported fields the first time, and again whenever they
+// were modified directly; it is then kept until the next change. Checking
+// it costs a lookup in EndpointsByCoordinate per cell, see current for
+// cheaper lookups of a single sector.
+func (l *Lattice) index() *latticeIndex {
+	if x := l.cached(); x != nil && x.synced(l) {
+		return x
+	}
+
+	x, _ := buildIndex(l)
+	l.idx.Store(x)
+
+	return x
+}
+
+// current returns the index of the lattice if it is fresh, and rebuilds it
+// otherwise. It may miss sectors both removed and added directly, so it is
+// only for the lookup of a sector whose key is checked, and for changes
+// made by the methods of the lattice, which keep index and fields alike.
+func (l *Lattice) current() *latticeIndex {
+	if x := l.cached(); x != nil && x.fresh(l) {
+		return x
+	}
+
+	return l.index()
+}
+
+// Reindex rebuilds the internal index of the lattice from its exported
+// fields, and reports the keys of EndpointsByCoordinate which do not have a
+// value per dimension. The index follows direct modifications of the fields
+// on its own, so this is never needed to read them.
+func (l *Lattice) Reindex() error {
+	x, err := buildIndex(l)
+	l.idx.Store(x)
+
+	return err
+}
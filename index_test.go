@@ -0,0 +1,222 @@
+package shuffle_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestReindex checks that direct changes to the exported fields are picked
+// up after reindexing the lattice.
+func TestReindex(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	l.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"foo"})
+
+	l.EndpointsByCoordinate["us-y⚡️1.1"] = []string{"bar"}
+	l.ValuesByDimension["az"] = append(l.ValuesByDimension["az"], "us-y")
+
+	if err = l.Reindex(); err != nil {
+		t.Fatalf("unable to reindex the lattice: %v", err)
+	}
+
+	e, err := l.GetEndpointsForSector([]string{"us-y", "1.1"})
+	if err != nil {
+		t.Fatalf("unable to fetch endpoints: %v", err)
+	}
+	if strings.Join(e, ", ") != "bar" {
+		t.Fatalf(
+			`illegal endpoints returned: expected: "bar", but got: "%s"`,
+			strings.Join(e, ", "),
+		)
+	}
+
+	// Keys which do not match the dimensions are reported.
+	l.EndpointsByCoordinate["us-z"] = []string{"baz"}
+	if err = l.Reindex(); err == nil {
+		t.Fatalf("expected error for a key with too few values")
+	}
+	if len(l.GetAllCoordinates()) != 2 {
+		t.Fatalf(
+			"illegal number of coordinates returned: expected 2, "+
+				"but got: %d", len(l.GetAllCoordinates()),
+		)
+	}
+}
+
+// TestUnindexedLattice checks that lattices not created with NewLattice
+// can be used.
+func TestUnindexedLattice(t *testing.T) {
+	l := &shuffle.Lattice{
+		DimensionNames: []string{"az"},
+		ValuesByDimension: map[string][]string{
+			"az": {"us-x", "us-y"},
+		},
+		EndpointsByCoordinate: map[string][]string{
+			"us-x": {"a", "b"},
+			"us-y": {"c", "d"},
+		},
+	}
+
+	e, err := l.GetEndpointsForSector([]string{"us-y"})
+	if err != nil {
+		t.Fatalf("unable to fetch endpoints: %v", err)
+	}
+	if strings.Join(e, ", ") != "c, d" {
+		t.Fatalf(
+			`illegal endpoints returned: expected: "c, d", but got: "%s"`,
+			strings.Join(e, ", "),
+		)
+	}
+
+	s, err := l.SimpleShuffleShard([]byte("foo"), 1)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+	if len(s.GetAllEndpoints()) != 2 {
+		t.Fatalf(
+			"illegal number of endpoints returned: expected 2, "+
+				"but got: %d", len(s.GetAllEndpoints()),
+		)
+	}
+}
+
+// TestDirectModifications checks that direct changes to the exported
+// fields are picked up without reindexing the lattice.
+func TestDirectModifications(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	l.AddEndpointsForSector([]string{"us-x"}, []string{"foo"})
+
+	l.EndpointsByCoordinate["us-y"] = []string{"bar"}
+	l.ValuesByDimension["az"] = append(l.ValuesByDimension["az"], "us-y")
+
+	e, err := l.GetEndpointsForSector([]string{"us-y"})
+	if err != nil || strings.Join(e, ", ") != "bar" {
+		t.Fatalf("illegal endpoints returned: %v, %v", e, err)
+	}
+	if n := len(l.GetAllCoordinates()); n != 2 {
+		t.Fatalf("illegal number of coordinates: expected 2, but got: %d", n)
+	}
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+
+	// Lattices decoded into a lattice created with NewLattice.
+	d, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	b, err := json.Marshal(l)
+	if err != nil {
+		t.Fatalf("unable to encode the lattice: %v", err)
+	}
+	if err = json.Unmarshal(b, d); err != nil {
+		t.Fatalf("unable to decode the lattice: %v", err)
+	}
+	if strings.Join(d.GetAllEndpoints(), ", ") != "bar, foo" {
+		t.Fatalf("illegal endpoints returned: %v", d.GetAllEndpoints())
+	}
+	if _, err = d.SimpleShuffleShard([]byte("foo"), 1); err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+
+	// Replaced fields.
+	l.DimensionNames = []string{"zone"}
+	l.ValuesByDimension = map[string][]string{"zone": {"us-z"}}
+	l.EndpointsByCoordinate = map[string][]string{"us-z": {"baz"}}
+	e, err = l.GetEndpointsForSector([]string{"us-z"})
+	if err != nil || strings.Join(e, ", ") != "baz" {
+		t.Fatalf("illegal endpoints returned: %v, %v", e, err)
+	}
+
+	// Endpoints replaced in a sector.
+	l.EndpointsByCoordinate["us-z"] = []string{"qux"}
+	e, _ = l.GetEndpointsForSector([]string{"us-z"})
+	if strings.Join(e, ", ") != "qux" {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+	if strings.Join(l.GetAllEndpoints(), ", ") != "qux" {
+		t.Fatalf("illegal endpoints returned: %v", l.GetAllEndpoints())
+	}
+	s, err := l.SimpleShuffleShard([]byte("foo"), 1)
+	if err != nil || strings.Join(s.GetAllEndpoints(), ", ") != "qux" {
+		t.Fatalf("illegal shard returned: %v, %v", s, err)
+	}
+
+	// A sector removed and another added, which keeps the number of keys.
+	delete(l.EndpointsByCoordinate, "us-z")
+	l.EndpointsByCoordinate["us-w"] = []string{"quux"}
+	l.ValuesByDimension["zone"] = []string{"us-w"}
+	c := l.GetAllCoordinates()
+	if len(c) != 1 || strings.Join(c[0], ", ") != "us-w" {
+		t.Fatalf("illegal coordinates returned: %v", c)
+	}
+	if e, _ = l.GetEndpointsForSector([]string{"us-z"}); e != nil {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+	e, _ = l.GetEndpointsForSector([]string{"us-w"})
+	if strings.Join(e, ", ") != "quux" {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+	s, err = l.SimpleShuffleShard([]byte("foo"), 1)
+	if err != nil || strings.Join(s.GetAllEndpoints(), ", ") != "quux" {
+		t.Fatalf("illegal shard returned: %v, %v", s, err)
+	}
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+
+	// And back, looking the sectors up first.
+	delete(l.EndpointsByCoordinate, "us-w")
+	l.EndpointsByCoordinate["us-z"] = []string{"qux"}
+	l.ValuesByDimension["zone"] = []string{"us-z"}
+	if e, _ = l.GetEndpointsForSector([]string{"us-w"}); e != nil {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+	e, _ = l.GetEndpointsForSector([]string{"us-z"})
+	if strings.Join(e, ", ") != "qux" {
+		t.Fatalf("illegal endpoints returned: %v", e)
+	}
+}
+
+// TestGetEndpointsForSectorAllocs checks that looking up a sector does not
+// allocate.
+func TestGetEndpointsForSectorAllocs(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	l.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"foo"})
+	l.AddEndpointsForSector([]string{"us-y", "1.1"}, []string{"bar"})
+
+	sec := []string{"us-y", "1.1"}
+	allocs := testing.AllocsPerRun(100, func() {
+		l.GetEndpointsForSector(sec)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, but got: %v", allocs)
+	}
+
+	// Lattices not created with NewLattice keep the index they build.
+	u := &shuffle.Lattice{
+		DimensionNames:        []string{"az"},
+		ValuesByDimension:     map[string][]string{"az": {"us-x"}},
+		EndpointsByCoordinate: map[string][]string{"us-x": {"a"}},
+	}
+	sec = []string{"us-x"}
+	allocs = testing.AllocsPerRun(100, func() {
+		u.GetEndpointsForSector(sec)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, but got: %v", allocs)
+	}
+}
@@ -0,0 +1,309 @@
+package shuffle
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CSVMapping tells LoadCSVInventory where to find the endpoint and the
+// sector of each host in the records of a CSV file. Columns are numbered
+// from zero.
+type CSVMapping struct {
+	// Header tells whether the first record names the columns, and must
+	// be skipped.
+	Header bool
+
+	// Endpoint is the column of the endpoint (for example, the host).
+	Endpoint int
+
+	// Dimensions maps the dimensions of the lattice to their columns,
+	// for example: {"az": 2, "rack": 3}.
+	Dimensions map[string]int
+}
+
+// YAMLMapping tells LoadYAMLInventory where to find the endpoint and the
+// sector of each host in the fields of a YAML file.
+type YAMLMapping struct {
+	// Endpoint is the field of the endpoint (for example, "host").
+	Endpoint string
+
+	// Dimensions maps the dimensions of the lattice to their fields, for
+	// example: {"az": "zone", "version": "version"}.
+	Dimensions map[string]string
+}
+
+// InventoryRowError is the problem with one host of an inventory. Row is
+// the number of the record in a CSV file, or the line of the host in a YAML
+// file, counting from one.
+type InventoryRowError struct {
+	Row int
+	Err error
+}
+
+// Error returns the row with its problem.
+func (e *InventoryRowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+// Unwrap returns the problem with the row.
+func (e *InventoryRowError) Unwrap() error {
+	return e.Err
+}
+
+// InventoryError lists the hosts of an inventory which were left out of
+// the lattice.
+type InventoryError struct {
+	Rows []*InventoryRowError
+}
+
+// Error returns all the row errors, separated by semicolons.
+func (e *InventoryError) Error() string {
+	var rows []string
+	for _, r := range e.Rows {
+		rows = append(rows, r.Error())
+	}
+
+	return "lattice: invalid inventory: " + strings.Join(rows, "; ")
+}
+
+// inventory collects the hosts of an inventory, grouped by sector, before
+// they are added to a lattice.
+type inventory struct {
+	dims    []string
+	sectors map[string][]string
+	eps     map[string][]string
+	errs    []*InventoryRowError
+}
+
+// newInventory creates an inventory for the dimensions of a mapping.
+func newInventory(dims []string) (*inventory, error) {
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("lattice: the mapping has no dimensions")
+	}
+	sort.Strings(dims)
+
+	return &inventory{
+		dims:    dims,
+		sectors: map[string][]string{},
+		eps:     map[string][]string{},
+	}, nil
+}
+
+// add records the host of a row, given the values of its endpoint and of
+// each dimension.
+func (inv *inventory) add(row int, ep string, sec []string) {
+	if ep == "" {
+		inv.fail(row, fmt.Errorf("no endpoint"))
+		return
+	}
+
+	for i, v := range sec {
+		if v == "" {
+			inv.fail(row, fmt.Errorf("no value for dimension %q", inv.dims[i]))
+			return
+		} else if strings.Contains(v, seperator) {
+			inv.fail(row, fmt.Errorf(
+				"value %q of dimension %q contains the reserved %q",
+				v, inv.dims[i], seperator,
+			))
+			return
+		}
+	}
+
+	k := strings.Join(sec, seperator)
+	inv.sectors[k] = sec
+	inv.eps[k] = append(inv.eps[k], ep)
+}
+
+// fail records the problem with a row.
+func (inv *inventory) fail(row int, err error) {
+	inv.errs = append(inv.errs, &InventoryRowError{row, err})
+}
+
+// lattice builds the lattice of the hosts. Hosts of the same sector are
+// added at once, in the order of their sectors.
+func (inv *inventory) lattice() (*Lattice, error) {
+	var keys []string
+
+	l, err := NewLattice(inv.dims)
+	if err != nil {
+		return nil, err
+	}
+
+	for k := range inv.sectors {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err = l.AddEndpointsForSector(inv.sectors[k], inv.eps[k]); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(inv.errs) > 0 {
+		return l, &InventoryError{inv.errs}
+	}
+
+	return l, nil
+}
+
+// LoadCSVInventory builds a lattice out of the hosts listed in a CSV file,
+// one host per record, with the dimensions of the mapping. Records may have
+// any number of fields.
+//
+// Hosts with a missing endpoint or dimension value are left out, and
+// reported in an *InventoryError; the lattice of the other hosts is
+// returned along with it. Any other error means nothing could be loaded.
+func LoadCSVInventory(r io.Reader, m CSVMapping) (*Lattice, error) {
+	var (
+		dims []string
+		cols []int
+		row  int
+	)
+
+	for d := range m.Dimensions {
+		dims = append(dims, d)
+	}
+
+	inv, err := newInventory(dims)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Endpoint < 0 {
+		return nil, fmt.Errorf("lattice: bad endpoint column %d", m.Endpoint)
+	}
+	for _, d := range inv.dims {
+		if m.Dimensions[d] < 0 {
+			return nil, fmt.Errorf(
+				"lattice: bad column %d for dimension %q",
+				m.Dimensions[d], d,
+			)
+		}
+		cols = append(cols, m.Dimensions[d])
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("lattice: unable to read inventory: %v", err)
+		}
+
+		if row++; row == 1 && m.Header {
+			continue
+		}
+
+		if m.Endpoint >= len(rec) {
+			inv.fail(row, fmt.Errorf("no column %d", m.Endpoint))
+			continue
+		}
+
+		sec := make([]string, len(cols))
+		for i, c := range cols {
+			if c < len(rec) {
+				sec[i] = strings.TrimSpace(rec[c])
+			}
+		}
+		inv.add(row, strings.TrimSpace(rec[m.Endpoint]), sec)
+	}
+
+	return inv.lattice()
+}
+
+// LoadYAMLInventory builds a lattice out of the hosts listed in a YAML
+// file, with the dimensions of the mapping. The file holds a sequence of
+// hosts, each a mapping of fields to scalar values:
+//
+//	# inventory.yaml
+//	- host: 10.0.0.1
+//	  zone: us-x
+//	  version: 1.1
+//
+// Errors are reported as with LoadCSVInventory, with the line of each host
+// as its row.
+func LoadYAMLInventory(r io.Reader, m YAMLMapping) (*Lattice, error) {
+	var (
+		dims []string
+		doc  yaml.Node
+	)
+
+	for d := range m.Dimensions {
+		dims = append(dims, d)
+	}
+
+	inv, err := newInventory(dims)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = yaml.NewDecoder(r).Decode(&doc); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("lattice: unable to read inventory: %v", err)
+	}
+
+	hosts := &doc
+	if hosts.Kind == yaml.DocumentNode && len(hosts.Content) > 0 {
+		hosts = hosts.Content[0]
+	}
+	if hosts.Kind != yaml.SequenceNode && hosts.Kind != 0 {
+		return nil, fmt.Errorf(
+			"lattice: unable to read inventory: line %d: expected a "+
+				"sequence of hosts", hosts.Line,
+		)
+	}
+
+	for _, h := range hosts.Content {
+		if h.Kind != yaml.MappingNode {
+			inv.fail(h.Line, fmt.Errorf("expected a mapping of fields"))
+			continue
+		}
+
+		// Scalar fields by name; the others are reported if they are used.
+		fields := map[string]*yaml.Node{}
+		for i := 0; i+1 < len(h.Content); i += 2 {
+			fields[h.Content[i].Value] = h.Content[i+1]
+		}
+
+		value := func(f string) (string, error) {
+			n, ok := fields[f]
+			if !ok {
+				return "", nil
+			} else if n.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("field %q is not a scalar", f)
+			}
+			return strings.TrimSpace(n.Value), nil
+		}
+
+		ep, err := value(m.Endpoint)
+		if err != nil {
+			inv.fail(h.Line, err)
+			continue
+		}
+
+		sec := make([]string, len(inv.dims))
+		for i, d := range inv.dims {
+			if sec[i], err = value(m.Dimensions[d]); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			inv.fail(h.Line, err)
+			continue
+		}
+
+		inv.add(h.Line, ep, sec)
+	}
+
+	return inv.lattice()
+}
@@ -0,0 +1,149 @@
+package shuffle_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestLoadCSVInventory tests loading a lattice from a CSV inventory.
+func TestLoadCSVInventory(t *testing.T) {
+	in := `host,rack,zone,version
+10.0.0.1,r1,us-x,1.1
+10.0.0.2,r2,us-x,1.1
+10.0.0.3,r1,us-y,1.1
+10.0.0.4, r2, us-y, 0.3
+10.0.0.5,r1
+,r2,us-x,0.3
+10.0.0.7,r1,us-z,
+`
+
+	l, err := shuffle.LoadCSVInventory(strings.NewReader(in), shuffle.CSVMapping{
+		Header:     true,
+		Endpoint:   0,
+		Dimensions: map[string]int{"az": 2, "version": 3},
+	})
+
+	var inv *shuffle.InventoryError
+	if !errors.As(err, &inv) {
+		t.Fatalf("expected an inventory error, but got: %v", err)
+	}
+
+	expected := []string{
+		`row 6: no value for dimension "az"`,
+		`row 7: no endpoint`,
+		`row 8: no value for dimension "version"`,
+	}
+	if len(inv.Rows) != len(expected) {
+		t.Fatalf("unexpected row errors: %v", err)
+	}
+	for i, r := range inv.Rows {
+		if r.Error() != expected[i] {
+			t.Fatalf(
+				"unexpected row error: expected %q, but got: %q",
+				expected[i], r.Error(),
+			)
+		}
+	}
+
+	if strings.Join(l.GetDimensionNames(), ", ") != "az, version" {
+		t.Fatalf("illegal dimensions: %v", l.GetDimensionNames())
+	}
+
+	for sec, eps := range map[string]string{
+		"us-x 1.1": "10.0.0.1, 10.0.0.2",
+		"us-y 1.1": "10.0.0.3",
+		"us-y 0.3": "10.0.0.4",
+	} {
+		e, err := l.GetEndpointsForSector(strings.Fields(sec))
+		if err != nil {
+			t.Fatalf("unable to fetch endpoints: %v", err)
+		}
+		if strings.Join(e, ", ") != eps {
+			t.Fatalf(
+				"illegal endpoints for %s: expected %q, but got: %q",
+				sec, eps, strings.Join(e, ", "),
+			)
+		}
+	}
+
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+
+	// Bad mappings and files load nothing.
+	for _, m := range []shuffle.CSVMapping{
+		{Dimensions: map[string]int{}},
+		{Endpoint: -1, Dimensions: map[string]int{"az": 1}},
+		{Dimensions: map[string]int{"az": -1}},
+	} {
+		if _, err = shuffle.LoadCSVInventory(strings.NewReader(in), m); err == nil {
+			t.Fatalf("expected an error for mapping %+v", m)
+		}
+	}
+
+	_, err = shuffle.LoadCSVInventory(
+		strings.NewReader("a,\"b\n"),
+		shuffle.CSVMapping{Dimensions: map[string]int{"az": 1}},
+	)
+	if err == nil || errors.As(err, &inv) {
+		t.Fatalf("expected a parse error, but got: %v", err)
+	}
+}
+
+// TestLoadYAMLInventory tests loading a lattice from a YAML inventory.
+func TestLoadYAMLInventory(t *testing.T) {
+	in := `- host: 10.0.0.1
+  zone: us-x
+  version: 1.1
+- host: 10.0.0.2
+  zone: us-y
+  version: 1.1
+- host: 10.0.0.3
+  zone: [us-x, us-y]
+  version: 1.1
+- zone: us-x
+  version: 0.3
+- just a host
+`
+
+	l, err := shuffle.LoadYAMLInventory(strings.NewReader(in), shuffle.YAMLMapping{
+		Endpoint:   "host",
+		Dimensions: map[string]string{"az": "zone", "version": "version"},
+	})
+
+	var inv *shuffle.InventoryError
+	if !errors.As(err, &inv) {
+		t.Fatalf("expected an inventory error, but got: %v", err)
+	}
+	if fmt.Sprint(err) != `lattice: invalid inventory: `+
+		`row 7: field "zone" is not a scalar; row 10: no endpoint; `+
+		`row 12: expected a mapping of fields` {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if strings.Join(l.GetAllEndpoints(), ", ") != "10.0.0.1, 10.0.0.2" ||
+		len(l.GetAllCoordinates()) != 2 {
+		t.Fatalf("illegal lattice: %+v", l)
+	}
+
+	// Empty files make empty lattices.
+	l, err = shuffle.LoadYAMLInventory(strings.NewReader(""), shuffle.YAMLMapping{
+		Endpoint:   "host",
+		Dimensions: map[string]string{"az": "zone"},
+	})
+	if err != nil || len(l.GetAllCoordinates()) != 0 {
+		t.Fatalf("unable to load an empty inventory: %v", err)
+	}
+
+	_, err = shuffle.LoadYAMLInventory(strings.NewReader("host: a"), shuffle.YAMLMapping{
+		Endpoint:   "host",
+		Dimensions: map[string]string{"az": "zone"},
+	})
+	if err == nil || errors.As(err, &inv) {
+		t.Fatalf("expected a format error, but got: %v", err)
+	}
+}
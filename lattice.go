@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,8 +39,35 @@ type Lattice struct {
 	// to use the seed as a sort of application ID to allow applications
 	// to consistently produce the same results.
 	Seed int64
+
+	// Duplicates is the policy for endpoints added to a sector while
+	// they are already in another cell. Cells are failure domains, so an
+	// endpoint in several cells breaks the isolation they provide; the
+	// default is to allow it nonetheless.
+	Duplicates DuplicatePolicy
+
+	// The internal representation of the lattice (a *latticeIndex), kept
+	// in sync with the exported fields by the methods of the lattice, and
+	// rebuilt by reads when the fields were modified directly; reads may
+	// run concurrently. The endpoints are only kept in the fields.
+	idx atomic.Value
 }
 
+// DuplicatePolicy decides what happens to endpoints added to a sector of a
+// lattice while they are already in another cell.
+type DuplicatePolicy int
+
+const (
+	// AllowDuplicates lets an endpoint be in several cells.
+	AllowDuplicates DuplicatePolicy = iota
+
+	// RejectDuplicates makes AddEndpointsForSector fail.
+	RejectDuplicates
+
+	// MoveDuplicates removes the endpoint from the other cells.
+	MoveDuplicates
+)
+
 // We need this because we can't have a slice for a key in a map,
 // which was intended to be used in `Lattice.EndpointsByCoordinate'.
 // Also, a `⚡️' looks really cool!
@@ -63,6 +91,28 @@ func set(arr []string) []string {
 	return s
 }
 
+// intersection returns the elements of a which are also in b, as a set.
+func intersection(a, b []string) []string {
+	var (
+		m = map[string]bool{}
+		s []string
+	)
+
+	for _, e := range b {
+		m[e] = true
+	}
+	for _, e := range a {
+		if m[e] {
+			s = append(s, e)
+		}
+	}
+
+	if len(s) == 0 {
+		return nil
+	}
+	return set(s)
+}
+
 // indexOf is a helper function to find the index of a matching string
 // in a slice.
 func indexOf(s []string, p string) int {
@@ -86,27 +136,79 @@ func NewLattice(dims []string) (*Lattice, error) {
 // NewLatticeWithSeed creates an N-dimensional Lattice for a given set of
 // dimension names, where each dimension represents a meaningful availability
 // axis. Seed is intended to be used as an application ID, so the same
-// application can create consistent results across restarts.
+// application can create consistent results across restarts. The dimensions
+// are sorted by name; the slice of the caller is left as it is.
 func NewLatticeWithSeed(seed int64, dims []string) (*Lattice, error) {
 	if len(dims) == 0 {
 		return nil, fmt.Errorf("lattice: at least one dimension is required")
 	}
 
-	// Initialize an empty lattice.
-	l := &Lattice{[]string{}, map[string][]string{}, map[string][]string{}, seed}
+	return newLattice(seed, set(dims)), nil
+}
 
-	// Sort the dimensions.
-	sort.Strings(dims)
+// NewOrderedLattice is like NewLattice, but keeps the dimensions in the
+// order they are given, such as by priority. See NewOrderedLatticeWithSeed.
+func NewOrderedLattice(dims []string) (*Lattice, error) {
+	return NewOrderedLatticeWithSeed(seed, dims)
+}
 
-	// Add dimension names to the lattice.
-	l.DimensionNames = set(dims)
+// NewOrderedLatticeWithSeed is like NewLatticeWithSeed, but keeps the
+// dimensions in the order they are given: sectors list their values in that
+// order, and shards are made dimension by dimension in that order too. The
+// dimensions must be distinct.
+func NewOrderedLatticeWithSeed(seed int64, dims []string) (*Lattice, error) {
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("lattice: at least one dimension is required")
+	} else if len(set(dims)) != len(dims) {
+		return nil, fmt.Errorf("lattice: duplicate dimensions in %v", dims)
+	}
+
+	return newLattice(seed, dims), nil
+}
+
+// newLattice creates an empty lattice with a copy of the given dimensions,
+// which must be a set, in their order.
+func newLattice(seed int64, dims []string) *Lattice {
+	l := &Lattice{
+		DimensionNames:        append([]string{}, dims...),
+		ValuesByDimension:     map[string][]string{},
+		EndpointsByCoordinate: map[string][]string{},
+		Seed:                  seed,
+	}
 
 	// Create an empty set for each of the dimensions.
 	for _, d := range l.DimensionNames {
 		l.ValuesByDimension[d] = []string{}
 	}
+	x := newLatticeIndex(len(l.DimensionNames))
+	x.stamp(l)
+	l.idx.Store(x)
+
+	return l
+}
+
+// Clone returns a deep copy of the lattice.
+func (l *Lattice) Clone() *Lattice {
+	c := &Lattice{
+		DimensionNames:        append([]string{}, l.DimensionNames...),
+		ValuesByDimension:     make(map[string][]string, len(l.ValuesByDimension)),
+		EndpointsByCoordinate: make(map[string][]string, len(l.EndpointsByCoordinate)),
+		Seed:                  l.Seed,
+		Duplicates:            l.Duplicates,
+	}
+
+	for d, v := range l.ValuesByDimension {
+		c.ValuesByDimension[d] = append([]string{}, v...)
+	}
+	for k, e := range l.EndpointsByCoordinate {
+		c.EndpointsByCoordinate[k] = append([]string{}, e...)
+	}
+
+	if x := l.cached(); x != nil && x.synced(l) {
+		c.idx.Store(x.clone(c))
+	}
 
-	return l, nil
+	return c
 }
 
 // AddEndpointsForSector adds all of the end-points for that are associated
@@ -119,23 +221,69 @@ func (l *Lattice) AddEndpointsForSector(sec, ep []string) error {
 		)
 	}
 
+	// The values of the sector must not collide with the key encoding.
+	for _, v := range sec {
+		if strings.Contains(v, seperator) {
+			return fmt.Errorf(
+				"lattice: sector value %q contains the reserved %q",
+				v, seperator,
+			)
+		}
+	}
+
 	// Construct the key.
 	k := strings.Join(sec, seperator)
 
+	// Apply the policy for endpoints which are in other cells; they are
+	// only moved once the endpoints were added.
+	var (
+		x     = l.current()
+		moves []SectorEndpoints
+	)
+	if l.Duplicates != AllowDuplicates {
+		x = l.index()
+		for _, c := range x.cells {
+			other := x.coordinates(c)
+			if strings.Join(other, seperator) == k {
+				continue
+			}
+
+			dups := intersection(x.endpoints(c), ep)
+			if len(dups) == 0 {
+				continue
+			}
+
+			if l.Duplicates == RejectDuplicates {
+				return fmt.Errorf(
+					"lattice: endpoints %v are already in sector %v",
+					dups, other,
+				)
+			}
+			moves = append(moves, SectorEndpoints{other, dups})
+		}
+	}
+
 	// If these are endpoints for that sector, append;
 	// otherwise, create a new entry for that sector.
+	// The endpoints are copied, so that the slice of the caller is not
+	// written to.
 	e, ok := l.EndpointsByCoordinate[k]
 	if ok {
-		ep = append(ep, e...)
+		ep = append(append([]string{}, ep...), e...)
 	}
 
 	l.EndpointsByCoordinate[k] = set(ep)
+	x.set(sec, k)
 
 	for i, d := range l.DimensionNames {
 		l.ValuesByDimension[d] = append(l.ValuesByDimension[d], sec[i])
 		l.ValuesByDimension[d] = set(l.ValuesByDimension[d])
 	}
 
+	for _, m := range moves {
+		l.removeEndpointsFromSector(m.Sector, m.Endpoints)
+	}
+
 	return nil
 }
 
@@ -147,7 +295,26 @@ func (l *Lattice) GetEndpointsForSector(sec []string) ([]string, error) {
 		)
 	}
 
-	return l.EndpointsByCoordinate[strings.Join(sec, seperator)], nil
+	// Only the key of the sector needs to be checked against the map: a
+	// cell whose key is gone, or a key without a cell, was changed directly.
+	x := l.current()
+	if c := x.find(sec); c != nil {
+		if eps, ok := l.EndpointsByCoordinate[c.key]; ok {
+			return eps, nil
+		}
+	} else {
+		k := strings.Join(sec, seperator)
+		if _, ok := l.EndpointsByCoordinate[k]; !ok {
+			return nil, nil
+		}
+	}
+
+	x = l.index()
+	if c := x.find(sec); c != nil {
+		return x.endpoints(c), nil
+	}
+
+	return nil, nil
 }
 
 // GetAllEndpoints gets all of the end-points in the lattice.
@@ -161,26 +328,32 @@ func (l *Lattice) GetAllEndpoints() []string {
 	return set(e)
 }
 
-// GetAllCoordinates gets a list of all cells in the lattice.
+// GetAllCoordinates gets a list of all cells in the lattice, ordered by
+// their sectors.
 func (l *Lattice) GetAllCoordinates() [][]string {
-	var (
-		t []string
-		c [][]string
-	)
+	var c [][]string
 
-	for k := range l.EndpointsByCoordinate {
-		t = append(t, k)
-	}
-	t = set(t)
-
-	// Now, we have the set of keys, we should split them up into tuples.
-	for _, k := range t {
-		c = append(c, strings.Split(k, seperator))
-	}
+	l.ForEachCell(func(sec, _ []string) bool {
+		c = append(c, sec)
+		return true
+	})
 
 	return c
 }
 
+// ForEachCell calls f with the sector and the endpoints of each cell of the
+// lattice, ordered by their sectors, until f returns false. The sector is
+// the caller's to keep, but the endpoints belong to the lattice and must not
+// be modified; neither must the lattice be modified before it returns.
+func (l *Lattice) ForEachCell(f func(sec, eps []string) bool) {
+	x := l.index()
+	for _, c := range x.cells {
+		if !f(x.coordinates(c), x.endpoints(c)) {
+			return
+		}
+	}
+}
+
 // GetDimensionality returns the number of dimensions a lattice has.
 func (l *Lattice) GetDimensionality() map[string]int {
 	m := make(map[string]int)
@@ -216,24 +389,28 @@ func (l *Lattice) GetDimensionSize(dName string) int {
 // SimulateFailure simulates failure of a
 // particular slice of cells in the lattice.
 func (l *Lattice) SimulateFailure(dName, dVal string) (*Lattice, error) {
-	sublattice, err := NewLattice(l.DimensionNames)
-	if err != nil {
-		return nil, err
-	}
+	var (
+		sublattice = newLattice(l.Seed, l.DimensionNames)
+		err        error
+	)
 
 	dIdx := indexOf(l.DimensionNames, dName)
 	if dIdx < 0 {
 		return nil, fmt.Errorf("lattice: unknown dimension name")
 	}
 
-	for c := range l.EndpointsByCoordinate {
-		// Because we joined as a key for `Lattice.EndpointsByCoordinate'.
-		s := strings.Split(c, seperator)
-		if s[dIdx] != dVal {
-			k := strings.Join(s, seperator)
-			sublattice.AddEndpointsForSector(s, l.EndpointsByCoordinate[k])
+	// The cells are copied as they are, so the duplicate policy of the
+	// lattice only applies to endpoints added to the sublattice later.
+	l.ForEachCell(func(sec, eps []string) bool {
+		if sec[dIdx] != dVal {
+			err = sublattice.AddEndpointsForSector(sec, eps)
 		}
+		return err == nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	sublattice.Duplicates = l.Duplicates
 
 	return sublattice, nil
 }
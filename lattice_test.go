@@ -328,3 +328,350 @@ func TestSimulateFailure(t *testing.T) {
 		)
 	}
 }
+
+// TestAddEndpointsForSectorSeparator checks that sector values colliding
+// with the key encoding are rejected.
+func TestAddEndpointsForSectorSeparator(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	err = l.AddEndpointsForSector(
+		[]string{"us-x⚡️1.1", "0.3"}, []string{"foo"},
+	)
+	if err == nil {
+		t.Fatalf("expected error for a value with the separator")
+	}
+
+	if len(l.GetAllCoordinates()) != 0 || len(l.GetAllEndpoints()) != 0 {
+		t.Fatalf("rejected sector was added to the lattice")
+	}
+}
+
+// TestClone checks that a cloned lattice is independent of the original.
+func TestClone(t *testing.T) {
+	l, err := shuffle.NewLatticeWithSeed(42, []string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	l.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"foo", "bar"})
+	l.AddEndpointsForSector([]string{"us-y", "0.3"}, []string{"baz"})
+
+	c := l.Clone()
+	if c.Seed != 42 ||
+		strings.Join(c.GetAllEndpoints(), ", ") != "bar, baz, foo" ||
+		len(c.GetAllCoordinates()) != 2 {
+		t.Fatalf("illegal clone: %+v", c)
+	}
+
+	c.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"qux"})
+	c.AddEndpointsForSector([]string{"us-z", "0.3"}, []string{"xyzzy"})
+	c.EndpointsByCoordinate["us-y⚡️0.3"][0] = "quux"
+
+	if strings.Join(l.GetAllEndpoints(), ", ") != "bar, baz, foo" ||
+		strings.Join(l.GetDimensionValues("az"), ", ") != "us-x, us-y" ||
+		len(l.GetAllCoordinates()) != 2 {
+		t.Fatalf("changes to the clone leaked into the original")
+	}
+
+	e, err := c.GetEndpointsForSector([]string{"us-x", "1.1"})
+	if err != nil {
+		t.Fatalf("unable to fetch endpoints: %v", err)
+	}
+	if strings.Join(e, ", ") != "bar, foo, qux" {
+		t.Fatalf(
+			`illegal endpoints returned: expected: "bar, foo, qux", `+
+				`but got: "%s"`, strings.Join(e, ", "),
+		)
+	}
+}
+
+// TestDuplicatePolicy tests the policies for endpoints in several cells.
+func TestDuplicatePolicy(t *testing.T) {
+	for _, p := range []shuffle.DuplicatePolicy{
+		shuffle.AllowDuplicates,
+		shuffle.RejectDuplicates,
+		shuffle.MoveDuplicates,
+	} {
+		l, err := shuffle.NewLattice([]string{"az"})
+		if err != nil {
+			t.Fatalf("unable to create a lattice: %v", err)
+		}
+		l.Duplicates = p
+
+		l.AddEndpointsForSector([]string{"us-x"}, []string{"foo", "bar"})
+		l.AddEndpointsForSector([]string{"us-y"}, []string{"baz"})
+
+		// Adding an endpoint again to its own cell is never a duplicate.
+		err = l.AddEndpointsForSector([]string{"us-x"}, []string{"foo"})
+		if err != nil {
+			t.Fatalf("unable to add endpoints: %v", err)
+		}
+
+		err = l.AddEndpointsForSector([]string{"us-y"}, []string{"foo", "qux"})
+		x, _ := l.GetEndpointsForSector([]string{"us-x"})
+		y, _ := l.GetEndpointsForSector([]string{"us-y"})
+		got := strings.Join(x, ", ") + " | " + strings.Join(y, ", ")
+
+		var expected string
+		switch p {
+		case shuffle.AllowDuplicates:
+			expected = "bar, foo | baz, foo, qux"
+		case shuffle.RejectDuplicates:
+			if err == nil {
+				t.Fatalf("duplicate endpoints were not rejected")
+			}
+			err, expected = nil, "bar, foo | baz"
+		case shuffle.MoveDuplicates:
+			expected = "bar | baz, foo, qux"
+		}
+
+		if err != nil {
+			t.Fatalf("unable to add endpoints: %v", err)
+		}
+		if got != expected {
+			t.Fatalf(
+				"illegal endpoints for policy %d: expected %q, but got: %q",
+				p, expected, got,
+			)
+		}
+		if p != shuffle.AllowDuplicates {
+			if err = l.Validate(); err != nil {
+				t.Fatalf("lattice is not valid: %v", err)
+			}
+		}
+		if c := l.Clone(); c.Duplicates != p {
+			t.Fatalf("policy was not cloned: %d", c.Duplicates)
+		}
+	}
+
+	// Moving the last endpoint of a cell removes the cell.
+	l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	l.Duplicates = shuffle.MoveDuplicates
+
+	l.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"foo"})
+	l.AddEndpointsForSector([]string{"us-y", "0.3"}, []string{"foo", "bar"})
+
+	if len(l.GetAllCoordinates()) != 1 ||
+		strings.Join(l.GetDimensionValues("az"), ", ") != "us-y" {
+		t.Fatalf("empty cell was not removed: %v", l.GetAllCoordinates())
+	}
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+}
+
+// TestSimulateFailureDuplicates tests that failures keep the cells of
+// lattices which already have duplicates, whatever their policy.
+func TestSimulateFailureDuplicates(t *testing.T) {
+	for _, p := range []shuffle.DuplicatePolicy{
+		shuffle.RejectDuplicates,
+		shuffle.MoveDuplicates,
+	} {
+		l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+		if err != nil {
+			t.Fatalf("unable to create a lattice: %v", err)
+		}
+
+		l.AddEndpointsForSector([]string{"x", "1"}, []string{"foo", "bar"})
+		l.AddEndpointsForSector([]string{"x", "2"}, []string{"foo", "baz"})
+		l.AddEndpointsForSector([]string{"y", "1"}, []string{"bar", "qux"})
+		l.Duplicates = p
+
+		s, err := l.SimulateFailure("az", "y")
+		if err != nil {
+			t.Fatalf("unable to simulate a failure: %v", err)
+		}
+
+		x1, _ := s.GetEndpointsForSector([]string{"x", "1"})
+		x2, _ := s.GetEndpointsForSector([]string{"x", "2"})
+		got := strings.Join(x1, ", ") + " | " + strings.Join(x2, ", ")
+		if got != "bar, foo | baz, foo" || len(s.GetAllCoordinates()) != 2 {
+			t.Fatalf(
+				"illegal cells for policy %d: %q, %v",
+				p, got, s.GetAllCoordinates(),
+			)
+		}
+		if s.Duplicates != p {
+			t.Fatalf("policy was not kept: %d", s.Duplicates)
+		}
+	}
+}
+
+// TestOrderedLattice tests that ordered lattices keep their dimensions in
+// the declared order, down to their shards.
+func TestOrderedLattice(t *testing.T) {
+	if _, err := shuffle.NewOrderedLattice([]string{"az", "az"}); err == nil {
+		t.Fatalf("duplicate dimensions were accepted")
+	}
+
+	l, err := shuffle.NewOrderedLatticeWithSeed(42, []string{"version", "az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	for _, v := range []string{"v1", "v2"} {
+		for _, az := range []string{"us-x", "us-y"} {
+			l.AddEndpointsForSector(
+				[]string{v, az},
+				[]string{v + az + "-0", v + az + "-1"},
+			)
+		}
+	}
+
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+	sec, err := l.Sector(shuffle.Coordinate{"az": "us-x", "version": "v2"})
+	if err != nil || strings.Join(sec, ", ") != "v2, us-x" {
+		t.Fatalf("illegal sector: %v, %v", sec, err)
+	}
+
+	sub, err := l.SimulateFailure("az", "us-y")
+	if err != nil {
+		t.Fatalf("unable to simulate failure: %v", err)
+	}
+	simple, err := l.SimpleShuffleShard([]byte("foo"), 1)
+	if err != nil {
+		t.Fatalf("unable to shard: %v", err)
+	}
+	rendezvous, err := l.RendezvousShuffleShard([]byte("foo"), 1)
+	if err != nil {
+		t.Fatalf("unable to shard: %v", err)
+	}
+	stateful, err := shuffle.NewStatefulSharderWithSeed(42).
+		StatefulShuffleShard(l, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to shard: %v", err)
+	}
+
+	for name, x := range map[string]*shuffle.Lattice{
+		"failure":    sub,
+		"simple":     simple,
+		"rendezvous": rendezvous,
+		"stateful":   stateful,
+	} {
+		if got := strings.Join(x.GetDimensionNames(), ", "); got != "version, az" {
+			t.Fatalf("%s: illegal dimensions: %q", name, got)
+		}
+		for _, c := range x.GetAllCoordinates() {
+			if !strings.HasPrefix(c[0], "v") {
+				t.Fatalf("%s: illegal sector: %v", name, c)
+			}
+		}
+	}
+
+	// Sectors of lattices with the dimensions in another order differ.
+	sorted, err := shuffle.NewLattice([]string{"version", "az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	if _, err = l.Diff(sorted); err == nil {
+		t.Fatalf("lattices with dimensions in another order were compared")
+	}
+}
+
+// TestInputsNotModified tests that the slices given to a lattice are left
+// as they are.
+func TestInputsNotModified(t *testing.T) {
+	dims := []string{"version", "az"}
+	for _, create := range []func([]string) (*shuffle.Lattice, error){
+		shuffle.NewLattice, shuffle.NewOrderedLattice,
+	} {
+		if _, err := create(dims); err != nil {
+			t.Fatalf("unable to create a lattice: %v", err)
+		}
+		if strings.Join(dims, ", ") != "version, az" {
+			t.Fatalf("dimensions were modified: %v", dims)
+		}
+	}
+
+	l, err := shuffle.NewLattice(dims)
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	l.AddEndpointsForSector([]string{"us-x", "v1"}, []string{"foo"})
+
+	// Endpoints with room to spare, which must not be written to either.
+	var (
+		sec   = []string{"us-x", "v1"}
+		buf   = []string{"qux", "bar", "-", "-"}
+		eps   = buf[:2]
+		other = []string{"us-y", "v2"}
+		more  = []string{"baz"}
+	)
+	if err = l.AddEndpointsForSector(sec, eps); err != nil {
+		t.Fatalf("unable to add endpoints: %v", err)
+	}
+	if err = l.AddEndpointsForSector(other, more); err != nil {
+		t.Fatalf("unable to add endpoints: %v", err)
+	}
+
+	if strings.Join(sec, ", ") != "us-x, v1" ||
+		strings.Join(buf, ", ") != "qux, bar, -, -" ||
+		strings.Join(other, ", ") != "us-y, v2" ||
+		strings.Join(more, ", ") != "baz" {
+		t.Fatalf("inputs were modified: %v, %v, %v, %v", sec, buf, other, more)
+	}
+
+	got, _ := l.GetEndpointsForSector(sec)
+	if strings.Join(got, ", ") != "bar, foo, qux" {
+		t.Fatalf("illegal endpoints: %v", got)
+	}
+}
+
+// TestForEachCell tests the traversal of the cells of a lattice.
+func TestForEachCell(t *testing.T) {
+	l := batchLattice(t)
+
+	var got []string
+	l.ForEachCell(func(sec, eps []string) bool {
+		e, err := l.GetEndpointsForSector(sec)
+		if err != nil || strings.Join(e, ", ") != strings.Join(eps, ", ") {
+			t.Fatalf("illegal endpoints for sector %v: %v", sec, eps)
+		}
+		got = append(got, strings.Join(sec, ""))
+		return true
+	})
+
+	if s := strings.Join(got, ", "); s != "x1, x2, x3, y1, y2, y3, z1, z2, z3" {
+		t.Fatalf("illegal order of the cells: %q", s)
+	}
+
+	// Returning false stops the traversal.
+	n := 0
+	l.ForEachCell(func(sec, _ []string) bool {
+		n++
+		return sec[0] != "y"
+	})
+	if n != 4 {
+		t.Fatalf("traversal did not stop: %d cells", n)
+	}
+}
+
+// BenchmarkClone clones a 3x3 lattice.
+func BenchmarkClone(b *testing.B) {
+	l := batchLattice(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		l.Clone()
+	}
+}
+
+// BenchmarkForEachCell traverses a 3x3 lattice.
+func BenchmarkForEachCell(b *testing.B) {
+	l := batchLattice(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		l.ForEachCell(func(_, _ []string) bool { return true })
+	}
+}
@@ -0,0 +1,100 @@
+package shuffle
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives measurements of the sharding operations of the package,
+// once installed with SetMetrics. Operations are named "simple" (for
+// SimpleShuffleShard and SimpleShuffleShardCells) and "stateful" (for the
+// allocations of a StatefulSharder). Implementations must be safe for
+// concurrent use, and should return quickly: they are called on the path of
+// the operations, with the lock of a StatefulSharder held.
+type Metrics interface {
+	// ShardDuration records how long an operation took, whether it
+	// succeeded or not.
+	ShardDuration(op string, d time.Duration)
+
+	// ShardError counts a failed operation; kind classifies the error,
+	// see ErrorKind.
+	ShardError(op, kind string)
+
+	// FragmentsStored records the number of fragments stored by the
+	// StatefulSharder which allocated a shard, identified by its name (see
+	// SetName).
+	FragmentsStored(sharder string, n int)
+
+	// SearchNodes counts the nodes explored by the search of a
+	// StatefulSharder for one shard.
+	SearchNodes(n int)
+
+	// LatticeSize records the number of values of a dimension of a named
+	// lattice, see ObserveLattice.
+	LatticeSize(lattice, dimension string, values int)
+}
+
+// metricsHolder wraps the installed Metrics, since an atomic.Value needs
+// values of a single concrete type.
+type metricsHolder struct {
+	m Metrics
+}
+
+// The installed Metrics, a metricsHolder.
+var metrics atomic.Value
+
+// SetMetrics installs m to receive the measurements of all the sharding
+// operations; a nil m turns the measurements off, which is the default.
+func SetMetrics(m Metrics) {
+	metrics.Store(metricsHolder{m})
+}
+
+// loadMetrics returns the installed Metrics, or nil if there are none.
+func loadMetrics() Metrics {
+	h, _ := metrics.Load().(metricsHolder)
+	return h.m
+}
+
+// ErrorKind classifies the errors of the sharding operations, as reported
+// to Metrics: "not_enough_cells", "no_endpoints", "not_enough_endpoints",
+// "no_shards", or "other" for any other error.
+func ErrorKind(err error) string {
+	switch {
+	case errors.Is(err, ErrNotEnoughCells):
+		return "not_enough_cells"
+	case errors.Is(err, ErrNoEndpoints):
+		return "no_endpoints"
+	case errors.Is(err, ErrNotEnoughEndpoints):
+		return "not_enough_endpoints"
+	case errors.Is(err, ErrNoShards):
+		return "no_shards"
+	}
+
+	return "other"
+}
+
+// observeShard reports an operation which started at start, and ended with
+// err.
+func observeShard(m Metrics, op string, start time.Time, err error) {
+	m.ShardDuration(op, time.Since(start))
+	if err != nil {
+		m.ShardError(op, ErrorKind(err))
+	}
+}
+
+// ObserveLattice reports the number of values of each dimension of a
+// lattice to the installed Metrics, under the given name. Lattices are not
+// reported by the sharding operations, since they change independently of
+// them; this is to be called whenever the lattice changes. A
+// LatticeReloader reports every lattice it loads, named by its path.
+func ObserveLattice(name string, l *Lattice) {
+	m := loadMetrics()
+	if m == nil {
+		return
+	}
+
+	for _, d := range l.DimensionNames {
+		m.LatticeSize(name, d, len(l.ValuesByDimension[d]))
+	}
+}
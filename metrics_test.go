@@ -0,0 +1,128 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// recorder is a Metrics which records the measurements as strings.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+	nodes  int
+}
+
+func (r *recorder) record(format string, a ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, fmt.Sprintf(format, a...))
+}
+
+func (r *recorder) ShardDuration(op string, d time.Duration) {
+	r.record("duration %s", op)
+}
+
+func (r *recorder) ShardError(op, kind string) {
+	r.record("error %s %s", op, kind)
+}
+
+func (r *recorder) FragmentsStored(sharder string, n int) {
+	r.record("fragments %s %d", sharder, n)
+}
+
+func (r *recorder) SearchNodes(n int) {
+	r.mu.Lock()
+	r.nodes += n
+	r.mu.Unlock()
+}
+
+func (r *recorder) LatticeSize(lattice, dimension string, values int) {
+	r.record("size %s %s %d", lattice, dimension, values)
+}
+
+// TestMetrics checks the measurements of the sharding operations.
+func TestMetrics(t *testing.T) {
+	lat, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	lat.AddEndpointsForSector([]string{"us-x"}, []string{"a", "b", "c"})
+	lat.AddEndpointsForSector([]string{"us-y"}, []string{"d", "e"})
+
+	r := &recorder{}
+	shuffle.SetMetrics(r)
+	defer shuffle.SetMetrics(nil)
+
+	lat.SimpleShuffleShard([]byte("foo"), 1)
+	lat.SimpleShuffleShard([]byte("foo"), 3)
+	lat.SimpleShuffleShardCells([]byte("foo"), 1, 3)
+
+	// The second shard would be the same as the first.
+	one, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	one.AddEndpointsForSector([]string{"us-x"}, []string{"a", "b"})
+
+	sharder := shuffle.NewStatefulSharder()
+	sharder.SetName("one")
+	sharder.StatefulShuffleShard(one, 2, 1)
+	sharder.StatefulShuffleShard(one, 2, 1)
+
+	// Lattices are only reported when observed, such as by a reloader.
+	shuffle.ObserveLattice("lat", lat)
+	path := filepath.Join(t.TempDir(), "inventory.csv")
+	writeFile(t, path, "a:80,us-x\nb:80,us-y\nc:80,us-z\n")
+	if _, err = shuffle.NewLatticeReloader(path, loadCSV, time.Second); err != nil {
+		t.Fatalf("unable to create a reloader: %v", err)
+	}
+
+	expected := []string{
+		"duration simple",
+		"duration simple", "error simple not_enough_endpoints",
+		"duration simple", "error simple not_enough_cells",
+		"duration stateful", "fragments one 1",
+		"duration stateful", "error stateful no_shards", "fragments one 1",
+		"size lat az 2", fmt.Sprintf("size %s az 3", path),
+	}
+	if fmt.Sprint(r.events) != fmt.Sprint(expected) {
+		t.Fatalf(
+			"unexpected measurements: expected %v, but got: %v",
+			expected, r.events,
+		)
+	}
+	if r.nodes < 2 {
+		t.Fatalf("search nodes were not counted: %d", r.nodes)
+	}
+
+	// Nothing is measured once the metrics are removed.
+	shuffle.SetMetrics(nil)
+	lat.SimpleShuffleShard([]byte("foo"), 1)
+	if len(r.events) != len(expected) {
+		t.Fatalf("measurements recorded after SetMetrics(nil)")
+	}
+}
+
+// TestErrorKind tests the classification of errors.
+func TestErrorKind(t *testing.T) {
+	for err, kind := range map[error]string{
+		shuffle.ErrNotEnoughCells:                          "not_enough_cells",
+		shuffle.ErrNoEndpoints:                             "no_endpoints",
+		fmt.Errorf("%w: 1", shuffle.ErrNotEnoughEndpoints): "not_enough_endpoints",
+		shuffle.ErrNoShards:                                "no_shards",
+		fmt.Errorf("foo"):                                  "other",
+	} {
+		if shuffle.ErrorKind(err) != kind {
+			t.Fatalf(
+				"bad kind for %q: expected %s, but got: %s",
+				err, kind, shuffle.ErrorKind(err),
+			)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package shuffle_test
+
+// race tells whether the tests run with the race detector; see race_test.go.
+const race = false
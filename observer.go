@@ -0,0 +1,97 @@
+package shuffle
+
+// StatefulEventKind is the kind of a decision of the search of a
+// StatefulSharder.
+type StatefulEventKind int
+
+const (
+	// EventCoordinateTried is sent when the search tries to take
+	// endpoints from the cell of a coordinate.
+	EventCoordinateTried StatefulEventKind = iota
+
+	// EventFragmentRejected is sent when a set of endpoints of a cell is
+	// left out of the shard; Reason tells why.
+	EventFragmentRejected
+
+	// EventSearchExhausted is sent when no endpoints of any cell fit in
+	// the shard at some depth of the search: cells were tried, and all of
+	// them were rejected. It is not sent when no cells are left.
+	EventSearchExhausted
+
+	// EventShardChosen is sent when a shard is allocated.
+	EventShardChosen
+
+	// EventAllocationFailed is sent when no shard can be allocated.
+	EventAllocationFailed
+)
+
+// String returns the name of the kind of event.
+func (k StatefulEventKind) String() string {
+	switch k {
+	case EventCoordinateTried:
+		return "coordinate tried"
+	case EventFragmentRejected:
+		return "fragment rejected"
+	case EventSearchExhausted:
+		return "search exhausted"
+	case EventShardChosen:
+		return "shard chosen"
+	case EventAllocationFailed:
+		return "allocation failed"
+	}
+
+	return "unknown"
+}
+
+// Reasons for rejecting a fragment, see StatefulEvent.
+const (
+	// The endpoints of the fragment are already shared by too many other
+	// shards.
+	RejectedCollision = "collision"
+
+	// Some endpoints of the fragment are in the shard already, from
+	// another cell.
+	RejectedDuplicate = "duplicate"
+
+	// The fragment fits, but the shard it completes shares too many
+	// endpoints with another shard.
+	RejectedShardCollision = "shard collision"
+)
+
+// StatefulEvent describes a decision of the search of a StatefulSharder,
+// see SetObserver. The slices of an event must not be modified.
+type StatefulEvent struct {
+	Kind StatefulEventKind
+
+	// Depth is the depth of the recursion of the search: the number of
+	// cells tentatively picked for the shard before the decision. It is
+	// zero for the events about the whole allocation.
+	Depth int
+
+	// Coordinate is the sector tried, and Fragment the endpoints which
+	// were rejected (with the Reason) when the kind calls for them.
+	Coordinate []string
+	Fragment   []string
+	Reason     string
+
+	// Shard is the allocated shard, or Err the reason none was.
+	Shard *Lattice
+	Err   error
+}
+
+// SetObserver installs an observer which is called on the decisions of the
+// search for shards, to debug allocations. A nil observer removes it. It
+// is called with the lock of the sharder held, and must not use it.
+func (shard *StatefulSharder) SetObserver(observer func(StatefulEvent)) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.observer = observer
+}
+
+// observe sends an event to the observer, if there is one.
+func (shard *StatefulSharder) observe(ev StatefulEvent) {
+	if shard.observer != nil {
+		shard.observer(ev)
+	}
+}
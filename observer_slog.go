@@ -0,0 +1,45 @@
+//go:build go1.21
+// +build go1.21
+
+package shuffle
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogObserver returns an observer for SetObserver which logs the decisions
+// of the search to logger: the decisions within the search at the debug
+// level, allocated shards at the info level, and failed allocations at the
+// warning level.
+func SlogObserver(logger *slog.Logger) func(StatefulEvent) {
+	return func(ev StatefulEvent) {
+		var (
+			level = slog.LevelDebug
+			attrs = []slog.Attr{slog.Int("depth", ev.Depth)}
+		)
+
+		switch ev.Kind {
+		case EventCoordinateTried:
+			attrs = append(attrs, slog.Any("coordinate", ev.Coordinate))
+		case EventFragmentRejected:
+			attrs = append(attrs,
+				slog.Any("coordinate", ev.Coordinate),
+				slog.Any("fragment", ev.Fragment),
+				slog.String("reason", ev.Reason),
+			)
+		case EventShardChosen:
+			level = slog.LevelInfo
+			attrs = append(attrs,
+				slog.Any("endpoints", ev.Shard.GetAllEndpoints()),
+			)
+		case EventAllocationFailed:
+			level = slog.LevelWarn
+			attrs = append(attrs, slog.Any("error", ev.Err))
+		}
+
+		logger.LogAttrs(
+			context.Background(), level, "shard: "+ev.Kind.String(), attrs...,
+		)
+	}
+}
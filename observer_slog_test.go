@@ -0,0 +1,42 @@
+//go:build go1.21
+// +build go1.21
+
+package shuffle_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+func TestSlogObserver(t *testing.T) {
+	lattice, err := shuffle.NewLattice([]string{"dimX"})
+	if err != nil {
+		t.Fatalf("unable to create new lattice: %v", err)
+	}
+	lattice.AddEndpointsForSector([]string{"x1"}, []string{"A", "B"})
+
+	var buf bytes.Buffer
+	sharder := shuffle.NewStatefulSharder()
+	sharder.SetObserver(shuffle.SlogObserver(slog.New(
+		slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}),
+	)))
+
+	sharder.StatefulShuffleShard(lattice, 2, 1)
+	sharder.StatefulShuffleShard(lattice, 2, 1)
+
+	for _, line := range []string{
+		`level=DEBUG msg="shard: coordinate tried" depth=0 coordinate=[x1]`,
+		`level=INFO msg="shard: shard chosen" depth=0 endpoints="[A B]"`,
+		`level=DEBUG msg="shard: fragment rejected" depth=0 coordinate=[x1] fragment="[A B]" reason=collision`,
+		`level=DEBUG msg="shard: search exhausted" depth=0`,
+		`level=WARN msg="shard: allocation failed" depth=0 error="No shards available"`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("Expected %q in the log, got:\n%s", line, buf.String())
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package shuffle_test
+
+import (
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+func TestStatefulShuffleShardObserver(t *testing.T) {
+	lattice, err := shuffle.NewLattice([]string{"dimX", "dimY"})
+	if err != nil {
+		t.Fatalf("unable to create new lattice: %v", err)
+	}
+	lattice.AddEndpointsForSector([]string{"x1", "y1"}, []string{"A", "B"})
+	lattice.AddEndpointsForSector([]string{"x2", "y2"}, []string{"C", "D"})
+
+	var events []shuffle.StatefulEvent
+	sharder := shuffle.NewStatefulSharder()
+	sharder.SetObserver(func(ev shuffle.StatefulEvent) {
+		events = append(events, ev)
+	})
+
+	shard, err := sharder.StatefulShuffleShard(lattice, 2, 3)
+	if err != nil {
+		t.Fatalf("Should have one valid shard from this config: %v", err)
+	}
+
+	last := events[len(events)-1]
+	if last.Kind != shuffle.EventShardChosen || last.Shard != shard {
+		t.Fatalf("Expected the chosen shard last, got %+v", last)
+	}
+
+	depths := map[int]bool{}
+	for _, ev := range events {
+		if ev.Kind == shuffle.EventCoordinateTried {
+			depths[ev.Depth] = true
+		}
+		if ev.Kind == shuffle.EventSearchExhausted {
+			t.Fatalf("Unexpected exhausted search in a successful one: %+v", events)
+		}
+	}
+	if !depths[0] || !depths[1] {
+		t.Fatalf("Expected coordinates tried at depths 0 and 1, got %+v", events)
+	}
+
+	// The same shard is all there is, and it is rejected.
+	events = nil
+	if _, err := sharder.StatefulShuffleShard(lattice, 2, 3); err == nil {
+		t.Fatalf("Should have no shard left from this config")
+	}
+
+	rejected, exhausted := 0, 0
+	for _, ev := range events {
+		if ev.Kind == shuffle.EventSearchExhausted {
+			exhausted++
+		}
+		if ev.Kind == shuffle.EventFragmentRejected {
+			if ev.Reason != shuffle.RejectedShardCollision || len(ev.Fragment) != 2 || len(ev.Coordinate) != 2 {
+				t.Fatalf("Unexpected rejection: %+v", ev)
+			}
+			rejected++
+		}
+	}
+	if rejected == 0 || exhausted == 0 {
+		t.Fatalf("Expected fragments to be rejected, got %+v", events)
+	}
+
+	last = events[len(events)-1]
+	if last.Kind != shuffle.EventAllocationFailed || last.Err != shuffle.ErrNoShards {
+		t.Fatalf("Expected a failed allocation last, got %+v", last)
+	}
+
+	sharder.SetObserver(nil)
+	events = nil
+	sharder.StatefulShuffleShard(lattice, 1, 3)
+	if len(events) != 0 {
+		t.Fatalf("Observer called after it was removed")
+	}
+}
@@ -0,0 +1,183 @@
+package shuffle
+
+import (
+	"fmt"
+	"math"
+)
+
+// ShardingPlan is the result of PlanSharding: the combinatorics of the
+// shards of a lattice for every possible number of endpoints per cell, and
+// the parameters recommended for the expected tenants.
+type ShardingPlan struct {
+	// Tenants and Isolation are the inputs of the plan.
+	Tenants   int
+	Isolation float64
+
+	// Endpoints is the number of endpoints in the lattice, and Cells the
+	// number of cells in a shard (see CellCapacity).
+	Endpoints int
+	Cells     int
+
+	// Estimates holds one estimate per number of endpoints per cell, from
+	// one up to the size of the smallest cell.
+	Estimates []ShardingEstimate
+
+	// Recommended is the estimate with the most endpoints per cell which
+	// meets the isolation target with room for all tenants, or nil if
+	// there is none.
+	Recommended *ShardingEstimate
+}
+
+// ShardingEstimate describes the shards of a lattice for a number of
+// endpoints per cell.
+type ShardingEstimate struct {
+	// EndpointsPerCell is the parameter the estimate is for, and
+	// ShardSize the number of endpoints in each shard.
+	EndpointsPerCell int
+	ShardSize        int
+
+	// SimpleShards estimates the number of distinct shards that
+	// SimpleShuffleShard can produce.
+	SimpleShards float64
+
+	// ExpectedOverlap is the expected number of endpoints shared by the
+	// simple shards of two tenants, and OverlapFraction the same as a
+	// fraction of the shard size.
+	ExpectedOverlap float64
+	OverlapFraction float64
+
+	// MaximumOverlap is the smallest maximum overlap that may leave room
+	// for all tenants with StatefulShuffleShard, or -1 if there is none;
+	// StatefulShards is the upper bound of the number of shards it can
+	// allocate with that overlap.
+	MaximumOverlap int
+	StatefulShards float64
+}
+
+// logBinomial returns the natural logarithm of the binomial coefficient
+// "n choose k".
+func logBinomial(n, k int) float64 {
+	if k < 0 || k > n {
+		return math.Inf(-1)
+	}
+
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+
+	return a - b - c
+}
+
+// statefulCapacity returns an upper bound of the number of shards of the
+// given size StatefulShuffleShard can allocate out of the given number of
+// endpoints. No two shards may share a fragment of maximumOverlap+1
+// endpoints, and each shard uses up all of its fragments of that size.
+func statefulCapacity(endpoints, size, maximumOverlap int) float64 {
+	if size <= maximumOverlap {
+		return math.Inf(1)
+	}
+
+	n := logBinomial(endpoints, maximumOverlap+1) -
+		logBinomial(size, maximumOverlap+1)
+
+	return math.Floor(math.Exp(n) + 1e-9)
+}
+
+// PlanSharding computes the capacity and the expected overlap of the shards
+// of a lattice, and recommends parameters for the expected number of
+// tenants. Isolation is the largest acceptable expected fraction of a shard
+// shared with the shard of another tenant, between zero and one.
+//
+// The estimates assume every cell along a diagonal of the lattice exists:
+// sparse lattices provide fewer shards than estimated.
+func PlanSharding(l *Lattice, tenants int, isolation float64) (
+	*ShardingPlan, error,
+) {
+	var (
+		x     = l.index()
+		p     *ShardingPlan
+		sizes []int
+		cells float64
+		diags float64
+		least int
+	)
+
+	if tenants < 1 {
+		return nil, fmt.Errorf("plan: at least one tenant is required")
+	} else if isolation <= 0 || isolation > 1 {
+		return nil, fmt.Errorf("plan: isolation must be in (0, 1]")
+	} else if len(x.cells) == 0 {
+		return nil, fmt.Errorf("plan: the lattice has no cells")
+	}
+
+	p = &ShardingPlan{
+		Tenants:   tenants,
+		Isolation: isolation,
+		Endpoints: len(l.GetAllEndpoints()),
+		Cells:     l.CellCapacity(),
+	}
+
+	least = math.MaxInt32
+	for _, c := range x.cells {
+		sizes = append(sizes, len(x.endpoints(c)))
+		if len(x.endpoints(c)) < least {
+			least = len(x.endpoints(c))
+		}
+	}
+
+	// The number of cells a diagonal may cross, and (as a logarithm) the
+	// number of distinct diagonals: the values picked from each dimension,
+	// times the ways of pairing them up.
+	cells = 1
+	for _, d := range l.GetDimensionNames() {
+		n := l.GetDimensionSize(d)
+		cells *= float64(n)
+		diags += logBinomial(n, p.Cells)
+	}
+	if len(l.GetDimensionNames()) > 1 {
+		f, _ := math.Lgamma(float64(p.Cells + 1))
+		diags += f * float64(len(l.GetDimensionNames())-1)
+	}
+
+	for epc := 1; epc <= least; epc++ {
+		e := ShardingEstimate{
+			EndpointsPerCell: epc,
+			ShardSize:        epc * p.Cells,
+			MaximumOverlap:   -1,
+		}
+
+		// Each cell is part of a shard with the same probability, and
+		// within a shared cell each tenant picks epc endpoints of it.
+		var combos float64
+		inShard := float64(p.Cells) / cells
+		for _, n := range sizes {
+			combos += logBinomial(n, epc)
+			e.ExpectedOverlap +=
+				inShard * inShard * float64(epc) * float64(epc) / float64(n)
+		}
+
+		combos = combos / float64(len(sizes)) * float64(p.Cells)
+		e.SimpleShards = math.Round(math.Exp(diags + combos))
+		e.OverlapFraction = e.ExpectedOverlap / float64(e.ShardSize)
+
+		for o := 0; o < e.ShardSize; o++ {
+			c := statefulCapacity(p.Endpoints, e.ShardSize, o)
+			if c >= float64(tenants) {
+				e.MaximumOverlap, e.StatefulShards = o, c
+				break
+			}
+		}
+
+		p.Estimates = append(p.Estimates, e)
+	}
+
+	for i := range p.Estimates {
+		e := &p.Estimates[i]
+		if e.OverlapFraction <= isolation &&
+			e.SimpleShards >= float64(tenants) {
+			p.Recommended = e
+		}
+	}
+
+	return p, nil
+}
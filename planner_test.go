@@ -0,0 +1,104 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestPlanSharding checks the combinatorics of a single cell lattice.
+func TestPlanSharding(t *testing.T) {
+	var eps []string
+	for i := 0; i < 20; i++ {
+		eps = append(eps, fmt.Sprintf("%d", i))
+	}
+
+	lat, err := shuffle.NewLattice([]string{"dimX"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+	lat.AddEndpointsForSector([]string{"x"}, eps)
+
+	p, err := shuffle.PlanSharding(lat, 1000, 0.25)
+	if err != nil {
+		t.Fatalf("unable to plan the sharding: %v", err)
+	}
+
+	if len(p.Estimates) != 20 || p.Cells != 1 || p.Endpoints != 20 {
+		t.Fatalf("illegal plan returned: %+v", p)
+	}
+
+	// There are "20 choose 4" shards of 4 endpoints, which share 4 * 4 / 20
+	// endpoints on average; the stateful sharder needs an overlap of 3 to
+	// fit 1000 tenants: "20 choose 3" / "4 choose 3" is only 285.
+	e := p.Estimates[3]
+	if e.EndpointsPerCell != 4 || e.ShardSize != 4 ||
+		e.SimpleShards != 4845 ||
+		!almost(e.ExpectedOverlap, 0.8, 1e-9) ||
+		!almost(e.OverlapFraction, 0.2, 1e-9) ||
+		e.MaximumOverlap != 3 || e.StatefulShards != 4845 {
+		t.Fatalf("illegal estimate returned: %+v", e)
+	}
+
+	// Five endpoints per cell is the most within the isolation target.
+	if p.Recommended == nil || p.Recommended.EndpointsPerCell != 5 {
+		t.Fatalf("illegal recommendation returned: %+v", p.Recommended)
+	}
+
+	if _, err = shuffle.PlanSharding(lat, 0, 0.25); err == nil {
+		t.Fatalf("expected error for no tenants")
+	}
+	if _, err = shuffle.PlanSharding(lat, 1000, 0); err == nil {
+		t.Fatalf("expected error for an isolation of zero")
+	}
+}
+
+// TestPlanShardingOverlap compares the expected overlap of a plan with the
+// overlap of simple shards.
+func TestPlanShardingOverlap(t *testing.T) {
+	lat := batchLattice(t)
+
+	p, err := shuffle.PlanSharding(lat, 1000, 0.5)
+	if err != nil {
+		t.Fatalf("unable to plan the sharding: %v", err)
+	}
+
+	var (
+		shards  []map[string]bool
+		overlap float64
+		pairs   float64
+	)
+
+	for _, id := range batchIDs(300) {
+		shd, err := lat.SimpleShuffleShard(id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		m := map[string]bool{}
+		for _, ep := range shd.GetAllEndpoints() {
+			m[ep] = true
+		}
+		shards = append(shards, m)
+	}
+
+	for i := range shards {
+		for j := i + 1; j < len(shards); j++ {
+			for ep := range shards[i] {
+				if shards[j][ep] {
+					overlap++
+				}
+			}
+			pairs++
+		}
+	}
+
+	e := p.Estimates[1]
+	if !almost(overlap/pairs/e.ExpectedOverlap, 1.0, 0.1) {
+		t.Fatalf(
+			"the expected overlap is off: expected: %f, but got: %f",
+			e.ExpectedOverlap, overlap/pairs,
+		)
+	}
+}
@@ -0,0 +1,239 @@
+package shuffle
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDurationBuckets are the upper bounds, in seconds, of the buckets of
+// the duration histograms of a PrometheusMetrics.
+var DefaultDurationBuckets = []float64{
+	0.00001, 0.0001, 0.001, 0.01, 0.1, 1, 10,
+}
+
+// PrometheusMetrics is a Metrics which keeps the measurements in memory,
+// and exposes them in the Prometheus text format (version 0.0.4), with no
+// dependency on the Prometheus client library. It serves them over HTTP,
+// for example:
+//
+//	m := shuffle.NewPrometheusMetrics()
+//	shuffle.SetMetrics(m)
+//	http.Handle("/metrics", m)
+//
+// The metrics are:
+//
+//	shuffle_shard_duration_seconds{op}                 histogram
+//	shuffle_shard_errors_total{op,kind}                counter
+//	shuffle_shard_fragments_stored{sharder}            gauge
+//	shuffle_shard_search_nodes_total                   counter
+//	shuffle_shard_lattice_values{lattice,dimension}    gauge
+type PrometheusMetrics struct {
+	mu        sync.Mutex
+	buckets   []float64
+	durations map[string]*histogram
+	errors    map[[2]string]uint64
+	fragments map[string]int
+	nodes     uint64
+	values    map[[2]string]int
+}
+
+// histogram is a Prometheus histogram; counts are per bucket, not
+// cumulative, the last one being for +Inf.
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewPrometheusMetrics creates an empty PrometheusMetrics, with the default
+// duration buckets.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return NewPrometheusMetricsWithBuckets(DefaultDurationBuckets)
+}
+
+// NewPrometheusMetricsWithBuckets creates an empty PrometheusMetrics, whose
+// duration histograms have the given bucket upper bounds, in seconds.
+func NewPrometheusMetricsWithBuckets(buckets []float64) *PrometheusMetrics {
+	b := append([]float64{}, buckets...)
+	sort.Float64s(b)
+
+	return &PrometheusMetrics{
+		buckets:   b,
+		durations: map[string]*histogram{},
+		errors:    map[[2]string]uint64{},
+		fragments: map[string]int{},
+		values:    map[[2]string]int{},
+	}
+}
+
+// ShardDuration implements Metrics.
+func (p *PrometheusMetrics) ShardDuration(op string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	h, ok := p.durations[op]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets)+1)}
+		p.durations[op] = h
+	}
+
+	s := d.Seconds()
+	h.counts[sort.SearchFloat64s(p.buckets, s)]++
+	h.sum += s
+	h.count++
+}
+
+// ShardError implements Metrics.
+func (p *PrometheusMetrics) ShardError(op, kind string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.errors[[2]string{op, kind}]++
+}
+
+// FragmentsStored implements Metrics.
+func (p *PrometheusMetrics) FragmentsStored(sharder string, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.fragments[sharder] = n
+}
+
+// SearchNodes implements Metrics.
+func (p *PrometheusMetrics) SearchNodes(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nodes += uint64(n)
+}
+
+// LatticeSize implements Metrics.
+func (p *PrometheusMetrics) LatticeSize(lattice, dimension string, values int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.values[[2]string{lattice, dimension}] = values
+}
+
+// escapeLabel escapes a label value for the text format.
+var escapeLabel = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatFloat formats a sample value for the text format.
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// sortPairs sorts the label values of series with two labels.
+func sortPairs(p [][2]string) {
+	sort.Slice(p, func(i, j int) bool {
+		if p[i][0] != p[j][0] {
+			return p[i][0] < p[j][0]
+		}
+		return p[i][1] < p[j][1]
+	})
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format. Series
+// are sorted by their labels.
+func (p *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	var (
+		b    bytes.Buffer
+		keys []string
+	)
+
+	// The metrics are formatted under the lock, and written after it.
+	p.mu.Lock()
+
+	fmt.Fprintln(&b, "# HELP shuffle_shard_duration_seconds Time taken by sharding operations.")
+	fmt.Fprintln(&b, "# TYPE shuffle_shard_duration_seconds histogram")
+	for op := range p.durations {
+		keys = append(keys, op)
+	}
+	sort.Strings(keys)
+	for _, op := range keys {
+		h, l := p.durations[op], escapeLabel.Replace(op)
+
+		var n uint64
+		for i, ub := range p.buckets {
+			n += h.counts[i]
+			fmt.Fprintf(&b,
+				"shuffle_shard_duration_seconds_bucket{op=\"%s\",le=\"%s\"} %d\n",
+				l, formatFloat(ub), n,
+			)
+		}
+		fmt.Fprintf(&b,
+			"shuffle_shard_duration_seconds_bucket{op=\"%s\",le=\"+Inf\"} %d\n",
+			l, h.count,
+		)
+		fmt.Fprintf(&b,
+			"shuffle_shard_duration_seconds_sum{op=\"%s\"} %s\n",
+			l, formatFloat(h.sum),
+		)
+		fmt.Fprintf(&b,
+			"shuffle_shard_duration_seconds_count{op=\"%s\"} %d\n", l, h.count,
+		)
+	}
+
+	fmt.Fprintln(&b, "# HELP shuffle_shard_errors_total Failed sharding operations, by kind of error.")
+	fmt.Fprintln(&b, "# TYPE shuffle_shard_errors_total counter")
+	var errs [][2]string
+	for k := range p.errors {
+		errs = append(errs, k)
+	}
+	sortPairs(errs)
+	for _, k := range errs {
+		fmt.Fprintf(&b,
+			"shuffle_shard_errors_total{op=\"%s\",kind=\"%s\"} %d\n",
+			escapeLabel.Replace(k[0]), escapeLabel.Replace(k[1]), p.errors[k],
+		)
+	}
+
+	fmt.Fprintln(&b, "# HELP shuffle_shard_fragments_stored Fragments stored by each stateful sharder.")
+	fmt.Fprintln(&b, "# TYPE shuffle_shard_fragments_stored gauge")
+	keys = keys[:0]
+	for s := range p.fragments {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	for _, s := range keys {
+		fmt.Fprintf(&b,
+			"shuffle_shard_fragments_stored{sharder=\"%s\"} %d\n",
+			escapeLabel.Replace(s), p.fragments[s],
+		)
+	}
+
+	fmt.Fprintln(&b, "# HELP shuffle_shard_search_nodes_total Nodes explored by stateful searches.")
+	fmt.Fprintln(&b, "# TYPE shuffle_shard_search_nodes_total counter")
+	fmt.Fprintf(&b, "shuffle_shard_search_nodes_total %d\n", p.nodes)
+
+	fmt.Fprintln(&b, "# HELP shuffle_shard_lattice_values Values of each dimension of each observed lattice.")
+	fmt.Fprintln(&b, "# TYPE shuffle_shard_lattice_values gauge")
+	var dims [][2]string
+	for k := range p.values {
+		dims = append(dims, k)
+	}
+	sortPairs(dims)
+	for _, k := range dims {
+		fmt.Fprintf(&b,
+			"shuffle_shard_lattice_values{lattice=\"%s\",dimension=\"%s\"} %d\n",
+			escapeLabel.Replace(k[0]), escapeLabel.Replace(k[1]), p.values[k],
+		)
+	}
+
+	p.mu.Unlock()
+
+	return b.WriteTo(w)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteTo(w)
+}
@@ -0,0 +1,82 @@
+package shuffle_test
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestPrometheusMetrics tests the text exposition of the metrics.
+func TestPrometheusMetrics(t *testing.T) {
+	m := shuffle.NewPrometheusMetricsWithBuckets([]float64{1, 0.1})
+
+	m.ShardDuration("simple", 50*time.Millisecond)
+	m.ShardDuration("simple", 500*time.Millisecond)
+	m.ShardDuration("simple", 5*time.Second)
+	m.ShardError("stateful", "no_shards")
+	m.ShardError("simple", "other")
+	m.ShardError("simple", "other")
+	m.FragmentsStored("one", 4)
+	m.FragmentsStored("one", 10)
+	m.FragmentsStored("two", 1)
+	m.SearchNodes(3)
+	m.SearchNodes(4)
+	m.LatticeSize("b", "version", 3)
+	m.LatticeSize("a", `a"z`, 2)
+	m.LatticeSize("a", "version", 1)
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	res, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unable to fetch metrics: %v", err)
+	}
+	defer res.Body.Close()
+
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("bad content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unable to read metrics: %v", err)
+	}
+
+	var samples []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			samples = append(samples, line)
+		}
+	}
+
+	expected := []string{
+		`shuffle_shard_duration_seconds_bucket{op="simple",le="0.1"} 1`,
+		`shuffle_shard_duration_seconds_bucket{op="simple",le="1"} 2`,
+		`shuffle_shard_duration_seconds_bucket{op="simple",le="+Inf"} 3`,
+		`shuffle_shard_duration_seconds_sum{op="simple"} 5.55`,
+		`shuffle_shard_duration_seconds_count{op="simple"} 3`,
+		`shuffle_shard_errors_total{op="simple",kind="other"} 2`,
+		`shuffle_shard_errors_total{op="stateful",kind="no_shards"} 1`,
+		`shuffle_shard_fragments_stored{sharder="one"} 10`,
+		`shuffle_shard_fragments_stored{sharder="two"} 1`,
+		`shuffle_shard_search_nodes_total 7`,
+		`shuffle_shard_lattice_values{lattice="a",dimension="a\"z"} 2`,
+		`shuffle_shard_lattice_values{lattice="a",dimension="version"} 1`,
+		`shuffle_shard_lattice_values{lattice="b",dimension="version"} 3`,
+	}
+	if strings.Join(samples, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf(
+			"unexpected metrics:\n%s\nexpected:\n%s",
+			strings.Join(samples, "\n"), strings.Join(expected, "\n"),
+		)
+	}
+
+	if !strings.Contains(string(b), "# TYPE shuffle_shard_duration_seconds histogram\n") {
+		t.Fatalf("missing metric type:\n%s", b)
+	}
+}
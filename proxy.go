@@ -0,0 +1,210 @@
+package shuffle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+)
+
+// TenantFunc extracts the tenant identifier from an incoming request.
+type TenantFunc func(req *http.Request) ([]byte, error)
+
+// HeaderTenant returns a TenantFunc which reads the tenant identifier from
+// the named request header. Requests without the header are rejected.
+func HeaderTenant(name string) TenantFunc {
+	return func(req *http.Request) ([]byte, error) {
+		v := req.Header.Get(name)
+		if v == "" {
+			return nil, fmt.Errorf("proxy: missing tenant header %q", name)
+		}
+
+		return []byte(v), nil
+	}
+}
+
+// TenantError is returned by ShardProxy.Shard when the tenant of a request
+// cannot be extracted from it.
+type TenantError struct {
+	Err error
+}
+
+// Error returns the error of the TenantFunc.
+func (e *TenantError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error of the TenantFunc.
+func (e *TenantError) Unwrap() error {
+	return e.Err
+}
+
+// ErrTooManyTenants is returned by ShardProxy.Shard when a new tenant would
+// exceed the MaximumTenants of the proxy.
+var ErrTooManyTenants = errors.New("proxy: too many tenants")
+
+// ShardProxy assigns each tenant to a shuffle shard of a lattice and routes
+// its requests only to the endpoints in that shard. Endpoints are expected
+// to be "host:port" pairs.
+type ShardProxy struct {
+	// Lattice holds the full set of backend endpoints.
+	Lattice *Lattice
+
+	// Source, when set, provides the lattice for every request instead of
+	// Lattice; see LatticeReloader.
+	Source LatticeSource
+
+	// EndpointsPerCell is the number of endpoints picked from each cell
+	// of the lattice for a tenant's shard.
+	EndpointsPerCell int
+
+	// MaximumOverlap is the largest number of endpoints two tenants may
+	// share; it is only used in stateful mode.
+	MaximumOverlap int
+
+	// Sharder, when set, switches the proxy to stateful mode: shards are
+	// allocated with StatefulShuffleShard and the assignment of a tenant
+	// is persisted in the sharder. Otherwise SimpleShuffleShard is used,
+	// which derives the shard from the tenant identifier on every call.
+	//
+	// Every new tenant permanently takes a shard out of the lattice, so in
+	// stateful mode the tenant identifiers must be trusted, for example
+	// set by an authenticating proxy in front of this one, or limited with
+	// MaximumTenants.
+	Sharder *StatefulSharder
+
+	// MaximumTenants is the largest number of tenants the Sharder may
+	// assign a shard to in stateful mode; requests of other tenants fail
+	// with ErrTooManyTenants. Zero means no limit.
+	MaximumTenants int
+
+	// Tenant extracts the tenant identifier from a request.
+	Tenant TenantFunc
+
+	// Scheme is the URL scheme used to reach the endpoints. It defaults
+	// to "http".
+	Scheme string
+
+	// Serializes the allocation of shards to new tenants, so that they
+	// do not exceed MaximumTenants.
+	mu sync.Mutex
+}
+
+// Shard returns the shard of the tenant making the request.
+func (p *ShardProxy) Shard(req *http.Request) (*Lattice, error) {
+	lattice := p.Lattice
+	if p.Source != nil {
+		lattice = p.Source.Lattice()
+	}
+
+	if lattice == nil {
+		return nil, fmt.Errorf("proxy: no lattice configured")
+	}
+	if p.Tenant == nil {
+		return nil, fmt.Errorf("proxy: no tenant function configured")
+	}
+
+	id, err := p.Tenant(req)
+	if err != nil {
+		return nil, &TenantError{err}
+	}
+
+	if p.Sharder == nil {
+		return lattice.SimpleShuffleShard(id, p.EndpointsPerCell)
+	}
+
+	if p.MaximumTenants > 0 {
+		if _, ok := p.Sharder.Assignment(id); !ok {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			if p.Sharder.Tenants() >= p.MaximumTenants {
+				return nil, ErrTooManyTenants
+			}
+		}
+	}
+
+	return p.Sharder.AssignShard(
+		id, lattice, p.EndpointsPerCell, p.MaximumOverlap,
+	)
+}
+
+// Endpoint picks the endpoint the request should be forwarded to, out of
+// the shard of the tenant making the request.
+func (p *ShardProxy) Endpoint(req *http.Request) (string, error) {
+	shard, err := p.Shard(req)
+	if err != nil {
+		return "", err
+	}
+
+	eps := shard.GetAllEndpoints()
+	if len(eps) == 0 {
+		return "", fmt.Errorf("proxy: no endpoints in shard")
+	}
+
+	return eps[rand.Intn(len(eps))], nil
+}
+
+// directorError is the context key of the error of the Director.
+type directorError struct{}
+
+// Director rewrites the request to target an endpoint in the shard of its
+// tenant; it is meant to be used as the Director of a ReverseProxy. When
+// no endpoint can be chosen the URL host is cleared, so that the transport
+// refuses the request, and the error is kept for ErrorHandler.
+func (p *ShardProxy) Director(req *http.Request) {
+	ep, err := p.Endpoint(req)
+	if err != nil {
+		*req = *req.WithContext(
+			context.WithValue(req.Context(), directorError{}, err),
+		)
+		req.URL.Host = ""
+		return
+	}
+
+	req.URL.Scheme = p.Scheme
+	if req.URL.Scheme == "" {
+		req.URL.Scheme = "http"
+	}
+	req.URL.Host = ep
+
+	// Don't let the standard library pick a default User-Agent.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// ErrorHandler answers the requests which failed; it is meant to be used
+// as the ErrorHandler of a ReverseProxy. Requests without a valid tenant
+// get a Bad Request, requests of tenants which cannot be given a shard get
+// a Service Unavailable, and other failures a Bad Gateway.
+func (p *ShardProxy) ErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	if e, ok := req.Context().Value(directorError{}).(error); ok {
+		err = e
+	}
+
+	var (
+		code   = http.StatusBadGateway
+		tenant *TenantError
+	)
+	switch {
+	case errors.As(err, &tenant):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrTooManyTenants), errors.Is(err, ErrNoShards):
+		code = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+// ReverseProxy returns a ReverseProxy which uses the Director and the
+// ErrorHandler of p.
+func (p *ShardProxy) ReverseProxy() *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Director:     p.Director,
+		ErrorHandler: p.ErrorHandler,
+	}
+}
@@ -0,0 +1,308 @@
+package shuffle_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// backends starts n test servers which reply with their own address and
+// returns their "host:port" pairs.
+func backends(t *testing.T, n int) []string {
+	var eps []string
+
+	for i := 0; i < n; i++ {
+		var addr string
+
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, addr)
+			},
+		))
+		t.Cleanup(srv.Close)
+
+		u, err := url.Parse(srv.URL)
+		if err != nil {
+			t.Fatalf("unable to parse server URL: %v", err)
+		}
+		addr = u.Host
+		eps = append(eps, addr)
+	}
+
+	return eps
+}
+
+// get sends a request for the tenant through the proxy and returns the
+// status code and the body of the response.
+func get(t *testing.T, proxy *httptest.Server, tenant string) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, proxy.URL, nil)
+	if err != nil {
+		t.Fatalf("unable to create request: %v", err)
+	}
+	if tenant != "" {
+		req.Header.Set("X-Tenant", tenant)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unable to send request: %v", err)
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("unable to read response: %v", err)
+	}
+
+	return res.StatusCode, string(b)
+}
+
+// TestShardProxy checks that tenants are only routed to their shard.
+func TestShardProxy(t *testing.T) {
+	eps := backends(t, 8)
+
+	lat, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	lat.AddEndpointsForSector([]string{"us-x"}, eps[:4])
+	lat.AddEndpointsForSector([]string{"us-y"}, eps[4:])
+
+	for _, sharder := range []*shuffle.StatefulSharder{
+		nil, shuffle.NewStatefulSharder(),
+	} {
+		p := &shuffle.ShardProxy{
+			Lattice:          lat,
+			EndpointsPerCell: 1,
+			MaximumOverlap:   1,
+			Sharder:          sharder,
+			Tenant:           shuffle.HeaderTenant("X-Tenant"),
+		}
+		rp := p.ReverseProxy()
+		rp.ErrorLog = log.New(ioutil.Discard, "", 0)
+		srv := httptest.NewServer(rp)
+
+		for _, tenant := range []string{"foo", "bar", "baz"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Tenant", tenant)
+			shd, err := p.Shard(req)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			for i := 0; i < 20; i++ {
+				code, body := get(t, srv, tenant)
+				if code != http.StatusOK {
+					t.Fatalf("unexpected status code: %d", code)
+				}
+				if !contains(body, shd.GetAllEndpoints()) {
+					t.Fatalf(
+						"request routed outside of the shard: expected "+
+							"one of %v, but got: %s",
+						shd.GetAllEndpoints(), body,
+					)
+				}
+			}
+		}
+
+		// Requests without a tenant are refused.
+		if code, _ := get(t, srv, ""); code != http.StatusBadRequest {
+			t.Fatalf(
+				"unexpected status code: expected %d, but got: %d",
+				http.StatusBadRequest, code,
+			)
+		}
+
+		srv.Close()
+	}
+}
+
+// TestShardProxyStateful checks that stateful assignments are persisted.
+func TestShardProxyStateful(t *testing.T) {
+	lat, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	lat.AddEndpointsForSector(
+		[]string{"us-x"}, []string{"a:80", "b:80", "c:80", "d:80"},
+	)
+
+	sharder := shuffle.NewStatefulSharder()
+	p := &shuffle.ShardProxy{
+		Lattice:          lat,
+		EndpointsPerCell: 2,
+		MaximumOverlap:   1,
+		Sharder:          sharder,
+		Tenant:           shuffle.HeaderTenant("X-Tenant"),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "foo")
+
+	first, err := p.Shard(req)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		shd, err := p.Shard(req)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		if fmt.Sprint(shd.GetAllEndpoints()) !=
+			fmt.Sprint(first.GetAllEndpoints()) {
+			t.Fatalf(
+				"assignment changed: expected %v, but got: %v",
+				first.GetAllEndpoints(), shd.GetAllEndpoints(),
+			)
+		}
+	}
+
+	if shd, ok := sharder.Assignment([]byte("foo")); !ok || shd != first {
+		t.Fatalf("assignment was not persisted in the sharder")
+	}
+
+	req.URL, _ = url.Parse("/path")
+	p.Director(req)
+	if !contains(req.URL.Host, first.GetAllEndpoints()) {
+		t.Fatalf(
+			"bad endpoint chosen: expected one of %v, but got: %s",
+			first.GetAllEndpoints(), req.URL.Host,
+		)
+	}
+	if req.URL.Scheme != "http" || req.URL.Path != "/path" {
+		t.Fatalf("bad URL rewrite: %s", req.URL)
+	}
+}
+
+// TestShardProxyMaximumTenants checks that new tenants are refused once the
+// limit is reached.
+func TestShardProxyMaximumTenants(t *testing.T) {
+	eps := backends(t, 4)
+
+	lat, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	lat.AddEndpointsForSector([]string{"us-x"}, eps)
+
+	p := &shuffle.ShardProxy{
+		Lattice:          lat,
+		EndpointsPerCell: 1,
+		MaximumOverlap:   0,
+		MaximumTenants:   2,
+		Sharder:          shuffle.NewStatefulSharder(),
+		Tenant:           shuffle.HeaderTenant("X-Tenant"),
+	}
+	srv := httptest.NewServer(p.ReverseProxy())
+	defer srv.Close()
+
+	for _, tenant := range []string{"foo", "bar", "foo"} {
+		if code, _ := get(t, srv, tenant); code != http.StatusOK {
+			t.Fatalf("unexpected status code for %s: %d", tenant, code)
+		}
+	}
+
+	if code, _ := get(t, srv, "baz"); code != http.StatusServiceUnavailable {
+		t.Fatalf(
+			"unexpected status code: expected %d, but got: %d",
+			http.StatusServiceUnavailable, code,
+		)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Tenant", "baz")
+	if _, err := p.Shard(req); !errors.Is(err, shuffle.ErrTooManyTenants) {
+		t.Fatalf("expected ErrTooManyTenants, but got: %v", err)
+	}
+	if n := p.Sharder.Tenants(); n != 2 {
+		t.Fatalf("illegal number of tenants: expected 2, but got: %d", n)
+	}
+
+	// Tenant errors are told apart.
+	req.Header.Del("X-Tenant")
+	var te *shuffle.TenantError
+	if _, err := p.Shard(req); !errors.As(err, &te) {
+		t.Fatalf("expected a TenantError, but got: %v", err)
+	}
+}
+
+// latticeSource is a LatticeSource whose lattice is swapped by the test.
+type latticeSource struct {
+	lattice *shuffle.Lattice
+}
+
+func (s *latticeSource) Lattice() *shuffle.Lattice {
+	return s.lattice
+}
+
+// TestShardProxyStatefulReload checks that tenants are moved off endpoints
+// which are no longer in the lattice.
+func TestShardProxyStatefulReload(t *testing.T) {
+	lat, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	lat.AddEndpointsForSector(
+		[]string{"us-x"}, []string{"a:80", "b:80", "c:80", "d:80"},
+	)
+
+	var (
+		src = &latticeSource{lat}
+		p   = &shuffle.ShardProxy{
+			Source:           src,
+			EndpointsPerCell: 2,
+			MaximumOverlap:   1,
+			Sharder:          shuffle.NewStatefulSharder(),
+			Tenant:           shuffle.HeaderTenant("X-Tenant"),
+		}
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+	)
+	req.Header.Set("X-Tenant", "foo")
+
+	first, err := p.Shard(req)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+
+	// A reload which keeps the endpoints of the shard keeps the shard.
+	more := lat.Clone()
+	more.AddEndpointsForSector([]string{"us-x"}, []string{"e:80"})
+	src.lattice = more
+	if shd, err := p.Shard(req); err != nil || shd != first {
+		t.Fatalf("assignment changed: %v, %v", shd, err)
+	}
+
+	// A reload which removes one of them moves the tenant.
+	gone := first.GetAllEndpoints()[0]
+	less, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+	for _, ep := range more.GetAllEndpoints() {
+		if ep != gone {
+			less.AddEndpointsForSector([]string{"us-x"}, []string{ep})
+		}
+	}
+	src.lattice = less
+
+	shd, err := p.Shard(req)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+	if contains(gone, shd.GetAllEndpoints()) || len(shd.GetAllEndpoints()) != 2 {
+		t.Fatalf(
+			"tenant still routed to %s: %v", gone, shd.GetAllEndpoints(),
+		)
+	}
+	if again, _ := p.Sharder.Assignment([]byte("foo")); again != shd {
+		t.Fatalf("new assignment was not persisted")
+	}
+}
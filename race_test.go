@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package shuffle_test
+
+// race tells whether the tests run with the race detector, which makes
+// sync.Pool drop items, and so allocate.
+const race = true
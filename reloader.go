@@ -0,0 +1,223 @@
+package shuffle
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatticeSource provides the current version of a lattice, which may change
+// between calls. The lattices it returns must not be modified.
+type LatticeSource interface {
+	Lattice() *Lattice
+}
+
+// LatticeLoader builds a lattice out of the contents of a file, for example
+// with LoadCSVInventory.
+type LatticeLoader func(r io.Reader) (*Lattice, error)
+
+// ReloadEvent describes an attempt of a LatticeReloader to reload its file.
+type ReloadEvent struct {
+	// Path is the file which was reloaded, and Time when it was.
+	Path string
+	Time time.Time
+
+	// Err is the reason the reload failed, or nil if it succeeded.
+	Err error
+
+	// Lattice is the lattice in use after the reload: the new one when it
+	// succeeded, and the last good one otherwise.
+	Lattice *Lattice
+}
+
+// LatticeReloader keeps a lattice in sync with the file it is loaded from.
+// The file is polled for changes; when it changes, it is loaded again and
+// the new lattice is validated (see Validate) before it replaces the old
+// one. Files which cannot be loaded, or which hold invalid or empty
+// lattices, leave the last good lattice in use.
+//
+// Lattices are swapped atomically, and never modified once in use, so a
+// LatticeReloader can be used as the Source of a ShardProxy.
+type LatticeReloader struct {
+	path     string
+	load     LatticeLoader
+	interval time.Duration
+
+	// The lattice in use, a *Lattice.
+	current atomic.Value
+
+	// Serializes reloads, and guards the fields below.
+	mu      sync.Mutex
+	hook    func(ReloadEvent)
+	modTime time.Time
+	size    int64
+	missing bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewLatticeReloader loads the lattice from the file at path, and returns a
+// reloader which polls the file for changes at the given interval once it
+// is started (see Start). It fails if the file cannot be loaded.
+func NewLatticeReloader(path string, load LatticeLoader, interval time.Duration) (
+	*LatticeReloader, error,
+) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("reloader: the interval must be positive")
+	}
+
+	r := &LatticeReloader{path: path, load: load, interval: interval}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Lattice returns the lattice in use.
+func (r *LatticeReloader) Lattice() *Lattice {
+	l, _ := r.current.Load().(*Lattice)
+	return l
+}
+
+// SetReloadHook installs a hook which is called with the outcome of every
+// reload, successful or not. A nil hook removes it. The hook is called by
+// the goroutine which reloads, and must not call Reload.
+func (r *LatticeReloader) SetReloadHook(hook func(ReloadEvent)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hook = hook
+}
+
+// Reload loads the file now, whether it changed or not. The new lattice is
+// used if it is valid, otherwise the error is returned and the last good
+// lattice is kept.
+func (r *LatticeReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.reload()
+}
+
+// reload loads the file and emits the event; r.mu must be held.
+func (r *LatticeReloader) reload() error {
+	l, err := r.read()
+	if err == nil {
+		r.current.Store(l)
+		ObserveLattice(r.path, l)
+	}
+
+	if r.hook != nil {
+		r.hook(ReloadEvent{
+			Path:    r.path,
+			Time:    time.Now(),
+			Err:     err,
+			Lattice: r.Lattice(),
+		})
+	}
+
+	return err
+}
+
+// read loads and validates the lattice in the file.
+func (r *LatticeReloader) read() (*Lattice, error) {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("reloader: unable to open lattice: %v", err)
+	}
+	defer f.Close()
+
+	// Changes are detected from the file that was read.
+	if fi, err := f.Stat(); err == nil {
+		r.modTime, r.size = fi.ModTime(), fi.Size()
+	}
+
+	l, err := r.load(f)
+	if err != nil {
+		return nil, fmt.Errorf("reloader: unable to load lattice: %v", err)
+	} else if l == nil {
+		return nil, fmt.Errorf("reloader: no lattice loaded")
+	}
+
+	if err = l.Validate(); err != nil {
+		return nil, fmt.Errorf("reloader: %v", err)
+	}
+
+	// A file truncated while it is written loads as a valid lattice with
+	// no cells, which must not replace the lattice in use. Valid lattices
+	// have no cell without endpoints.
+	if len(l.EndpointsByCoordinate) == 0 {
+		return nil, fmt.Errorf("reloader: the lattice has no cells")
+	}
+
+	return l, nil
+}
+
+// poll reloads the file if it changed since it was last read. A missing
+// file is only reported once, until it is back.
+func (r *LatticeReloader) poll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		if !r.missing {
+			r.missing = true
+			r.reload()
+		}
+		return
+	}
+
+	if !r.missing && fi.ModTime().Equal(r.modTime) && fi.Size() == r.size {
+		return
+	}
+
+	r.missing = false
+	r.reload()
+}
+
+// Start polls the file for changes in a new goroutine, until Stop is
+// called. Starting a started reloader does nothing.
+func (r *LatticeReloader) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stop != nil {
+		return
+	}
+
+	r.stop, r.done = make(chan struct{}), make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+
+		t := time.NewTicker(r.interval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				r.poll()
+			}
+		}
+	}(r.stop, r.done)
+}
+
+// Stop stops polling the file, and waits for a reload in progress. The
+// lattice in use stays available.
+func (r *LatticeReloader) Stop() {
+	r.mu.Lock()
+	stop, done := r.stop, r.done
+	r.stop, r.done = nil, nil
+	r.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
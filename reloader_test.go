@@ -0,0 +1,167 @@
+package shuffle_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// loadCSV loads the inventories of the reloader tests. Hosts in several
+// zones make invalid lattices.
+func loadCSV(r io.Reader) (*shuffle.Lattice, error) {
+	l, err := shuffle.LoadCSVInventory(r, shuffle.CSVMapping{
+		Endpoint:   0,
+		Dimensions: map[string]int{"az": 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.Duplicates = shuffle.RejectDuplicates
+	return l, nil
+}
+
+// writeFile atomically replaces the contents of a file, making sure the
+// change can be told from its modification time.
+func writeFile(t *testing.T, path, data string) {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		t.Fatalf("unable to write file: %v", err)
+	}
+
+	mt := time.Now().Add(time.Duration(len(data)) * time.Second)
+	if err := os.Chtimes(tmp, mt, mt); err != nil {
+		t.Fatalf("unable to touch file: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("unable to rename file: %v", err)
+	}
+}
+
+// nextEvent waits for a reload event.
+func nextEvent(t *testing.T, events chan shuffle.ReloadEvent) shuffle.ReloadEvent {
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for a reload")
+	}
+
+	return shuffle.ReloadEvent{}
+}
+
+// TestLatticeReloader tests that lattices are reloaded when their file
+// changes, and that bad files are not used.
+func TestLatticeReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.csv")
+	writeFile(t, path, "a:80,us-x\nb:80,us-y\n")
+
+	if _, err := shuffle.NewLatticeReloader(
+		filepath.Join(t.TempDir(), "missing.csv"), loadCSV, time.Millisecond,
+	); err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+
+	r, err := shuffle.NewLatticeReloader(path, loadCSV, time.Millisecond)
+	if err != nil {
+		t.Fatalf("unable to create a reloader: %v", err)
+	}
+
+	first := r.Lattice()
+	if strings.Join(first.GetAllEndpoints(), ", ") != "a:80, b:80" {
+		t.Fatalf("illegal lattice loaded: %v", first.GetAllEndpoints())
+	}
+
+	events := make(chan shuffle.ReloadEvent, 10)
+	r.SetReloadHook(func(ev shuffle.ReloadEvent) { events <- ev })
+	r.Start()
+	defer r.Stop()
+
+	writeFile(t, path, "a:80,us-x\nb:80,us-y\nc:80,us-z\n")
+	ev := nextEvent(t, events)
+	if ev.Err != nil || ev.Path != path || ev.Lattice != r.Lattice() {
+		t.Fatalf("unexpected reload event: %+v", ev)
+	}
+	second := r.Lattice()
+	if strings.Join(second.GetAllEndpoints(), ", ") != "a:80, b:80, c:80" {
+		t.Fatalf("illegal lattice reloaded: %v", second.GetAllEndpoints())
+	}
+
+	// Neither unparsable, invalid nor empty lattices are used.
+	for _, data := range []string{
+		"a:80,\"us-x\n", "a:80,us-x\na:80,us-y\n", "", "\n",
+	} {
+		writeFile(t, path, data)
+		if ev = nextEvent(t, events); ev.Err == nil || ev.Lattice != second {
+			t.Fatalf("bad file was used: %+v", ev)
+		}
+		if r.Lattice() != second {
+			t.Fatalf("the last good lattice was not kept")
+		}
+	}
+
+	if err = os.Remove(path); err != nil {
+		t.Fatalf("unable to remove file: %v", err)
+	}
+	if ev = nextEvent(t, events); ev.Err == nil || r.Lattice() != second {
+		t.Fatalf("missing file was not reported: %+v", ev)
+	}
+
+	// Once stopped, changes are ignored.
+	r.Stop()
+	writeFile(t, path, "d:80,us-x\n")
+	time.Sleep(10 * time.Millisecond)
+	if r.Lattice() != second || len(events) != 0 {
+		t.Fatalf("lattice reloaded after Stop")
+	}
+
+	if err = r.Reload(); err != nil {
+		t.Fatalf("unable to reload: %v", err)
+	}
+	if strings.Join(r.Lattice().GetAllEndpoints(), ", ") != "d:80" {
+		t.Fatalf("illegal lattice reloaded: %v", r.Lattice().GetAllEndpoints())
+	}
+}
+
+// TestShardProxySource checks that the proxy shards the lattice of its
+// source.
+func TestShardProxySource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inventory.csv")
+	writeFile(t, path, "a:80,us-x\n")
+
+	r, err := shuffle.NewLatticeReloader(path, loadCSV, time.Hour)
+	if err != nil {
+		t.Fatalf("unable to create a reloader: %v", err)
+	}
+
+	p := &shuffle.ShardProxy{
+		Source:           r,
+		EndpointsPerCell: 1,
+		Tenant:           shuffle.HeaderTenant("X-Tenant"),
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Tenant", "foo")
+
+	for _, ep := range []string{"a:80", "b:80"} {
+		writeFile(t, path, ep+",us-x\n")
+		if err = r.Reload(); err != nil {
+			t.Fatalf("unable to reload: %v", err)
+		}
+
+		got, err := p.Endpoint(req)
+		if err != nil {
+			t.Fatalf("unable to pick an endpoint: %v", err)
+		}
+		if got != ep {
+			t.Fatalf("bad endpoint chosen: expected %s, but got: %s", ep, got)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package shuffle
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// rendezvousWeight is the weight of a candidate (a dimension value, or an
+// endpoint) for an identification in rendezvous hashing.
+func rendezvousWeight(seed int64, id []byte, scope, candidate string) uint64 {
+	h := murmur3.New64WithSeed(uint32(seed))
+	h.Write(id)
+	h.Write([]byte{0})
+	h.Write([]byte(scope))
+	h.Write([]byte{0})
+	h.Write([]byte(candidate))
+
+	return h.Sum64()
+}
+
+// rendezvousRank orders candidates by decreasing weight for an
+// identification; ties are broken by the candidates themselves.
+func rendezvousRank(seed int64, id []byte, scope string, candidates []string) []string {
+	var (
+		ranked = append([]string{}, candidates...)
+		w      = make(map[string]uint64, len(candidates))
+	)
+
+	for _, c := range candidates {
+		w[c] = rendezvousWeight(seed, id, scope, c)
+	}
+
+	sort.Slice(ranked, func(x, y int) bool {
+		if w[ranked[x]] != w[ranked[y]] {
+			return w[ranked[x]] > w[ranked[y]]
+		}
+		return ranked[x] < ranked[y]
+	})
+
+	return ranked
+}
+
+// RendezvousShuffleShard computes shuffle shards like SimpleShuffleShard,
+// but with rendezvous (highest random weight) hashing instead of shuffles.
+// Each dimension value and each endpoint gets a weight derived from the
+// identification, and the shard takes the heaviest ones: the cells pair up
+// the values of each dimension in order of weight, and the heaviest epc
+// endpoints of each cell are picked.
+//
+// Since weights do not depend on the other candidates, adding or removing
+// an endpoint only changes the shards which contain it (as long as no cell
+// becomes empty, and no dimension value appears or disappears).
+func (l *Lattice) RendezvousShuffleShard(id []byte, epc int) (*Lattice, error) {
+	var (
+		ranked [][]string
+		coords []string
+		cells  int
+		shard  *Lattice
+		x      = l.index()
+		chosen = map[string]bool{}
+
+		err error
+	)
+
+	if epc < 0 {
+		return nil, fmt.Errorf(
+			"shard: negative number of endpoints per cell: %d", epc,
+		)
+	}
+	shard = newLattice(l.Seed, l.DimensionNames)
+
+	// Rank the values of each dimension.
+	for _, d := range l.GetDimensionNames() {
+		ranked = append(
+			ranked, rendezvousRank(l.Seed, id, d, l.GetDimensionValues(d)),
+		)
+	}
+
+	// As with simple sharding, a shard spans every cell of a one
+	// dimensional lattice, and a diagonal otherwise.
+	cells = l.CellCapacity()
+	for i := 0; i < cells; i++ {
+		coords = []string{}
+		for j := range ranked {
+			coords = append(coords, ranked[j][i])
+		}
+
+		c := x.find(coords)
+		if c == nil || len(x.endpoints(c)) <= 0 {
+			return nil, ErrNoEndpoints
+		} else if len(x.endpoints(c)) < epc {
+			return nil, fmt.Errorf(
+				"%w in sector %v: %d < %d",
+				ErrNotEnoughEndpoints, coords, len(x.endpoints(c)), epc,
+			)
+		}
+
+		// Take the heaviest endpoints not in the shard yet, an endpoint
+		// may be in several cells.
+		var eps []string
+		for _, e := range rendezvousRank(l.Seed, id, "", x.endpoints(c)) {
+			if len(eps) == epc {
+				break
+			} else if !chosen[e] {
+				chosen[e] = true
+				eps = append(eps, e)
+			}
+		}
+		if len(eps) < epc {
+			return nil, fmt.Errorf(
+				"%w in sector %v: %d distinct < %d",
+				ErrNotEnoughEndpoints, coords, len(eps), epc,
+			)
+		}
+
+		err = shard.AddEndpointsForSector(coords, eps)
+		if err != nil {
+			return nil, fmt.Errorf("shard: unable to add endpoints: %v", err)
+		}
+	}
+
+	return shard, nil
+}
@@ -0,0 +1,103 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// shardFunc computes a shard of a lattice.
+type shardFunc func(l *shuffle.Lattice, id []byte, epc int) (
+	*shuffle.Lattice, error,
+)
+
+// churn returns the fraction of tenants whose shard differs between two
+// lattices, and checks that all of them satisfy the predicate.
+func churn(
+	t *testing.T, f shardFunc, old, new *shuffle.Lattice, ids [][]byte,
+	moved func(a, b []string) bool,
+) float64 {
+	var changed float64
+
+	for _, id := range ids {
+		a, err := f(old, id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		b, err := f(new, id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		x, y := a.GetAllEndpoints(), b.GetAllEndpoints()
+		if fmt.Sprint(x) != fmt.Sprint(y) {
+			changed++
+			if moved != nil && !moved(x, y) {
+				t.Fatalf("unexpected change of shard: %v -> %v", x, y)
+			}
+		}
+	}
+
+	return changed / float64(len(ids))
+}
+
+// TestRendezvousShuffleShard checks that membership changes only move the
+// shards which contain the endpoint.
+func TestRendezvousShuffleShard(t *testing.T) {
+	var (
+		ids  = batchIDs(2000)
+		old  = batchLattice(t)
+		less = batchLattice(t)
+		more = batchLattice(t)
+		hrw  = (*shuffle.Lattice).RendezvousShuffleShard
+		smp  = (*shuffle.Lattice).SimpleShuffleShard
+	)
+
+	// Remove an endpoint from one lattice, and add one to another.
+	less.EndpointsByCoordinate["x⚡️1"] = less.EndpointsByCoordinate["x⚡️1"][1:]
+	more.AddEndpointsForSector([]string{"x", "1"}, []string{"x1-new"})
+
+	for _, id := range ids[:100] {
+		shd, err := old.RendezvousShuffleShard(id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		if len(shd.GetAllEndpoints()) != 6 ||
+			len(shd.GetAllCoordinates()) != 3 {
+			t.Fatalf("illegal shard returned: %v", shd.GetAllEndpoints())
+		}
+	}
+
+	if _, err := old.RendezvousShuffleShard(ids[0], -1); err == nil {
+		t.Fatalf("expected error for a negative number of endpoints")
+	}
+
+	// Only the shards with the removed endpoint move.
+	removed := churn(t, hrw, old, less, ids, func(a, b []string) bool {
+		return contains("x1-0", a) && !contains("x1-0", b)
+	})
+
+	// Only the shards which now get the new endpoint move.
+	added := churn(t, hrw, old, more, ids, func(a, b []string) bool {
+		return !contains("x1-new", a) && contains("x1-new", b)
+	})
+
+	simple := churn(t, smp, old, less, ids, nil)
+	t.Logf(
+		"tenants moved: rendezvous: %.3f (removal), %.3f (addition); "+
+			"simple: %.3f (removal)", removed, added, simple,
+	)
+
+	// A cell is part of a third of the shards, and an endpoint is then
+	// picked one time out of five: about 6.7% of the tenants.
+	if removed == 0 || removed > 0.1 || added == 0 || added > 0.1 {
+		t.Fatalf("too many tenants moved: %f, %f", removed, added)
+	}
+	if simple <= removed {
+		t.Fatalf(
+			"rendezvous sharding moved more tenants than simple sharding: "+
+				"%f > %f", removed, simple,
+		)
+	}
+}
@@ -7,72 +7,179 @@
 package shuffle
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
+	"time"
 
 	"github.com/spaolacci/murmur3"
 )
 
+// orderSalt is mixed into the seed used to order the endpoints of a shard.
+const orderSalt = 0x5bd1e995
+
+// shardTable holds the parts of a lattice needed to compute simple shuffle
+// shards, so that they are computed once when sharding many identifiers.
+// It must be treated as read-only, and is safe for concurrent use.
+type shardTable struct {
+	seed     int64
+	names    []string
+	values   [][]string
+	cells    *latticeIndex
+	capacity int
+}
+
+// newShardTable precomputes the shard table of a lattice.
+func newShardTable(l *Lattice) *shardTable {
+	t := &shardTable{
+		seed:     l.Seed,
+		names:    l.GetDimensionNames(),
+		cells:    l.index(),
+		capacity: math.MaxInt32,
+	}
+
+	// The values of each dimension, sorted; and which dimension
+	// has the smallest number of values in it?
+	for _, d := range t.names {
+		v := l.GetDimensionValues(d)
+		if len(v) < t.capacity {
+			t.capacity = len(v)
+		}
+		t.values = append(t.values, v)
+	}
+
+	return t
+}
+
+// ErrNotEnoughCells is returned when a shard is asked to span more cells
+// than the lattice can provide, see SimpleShuffleShardCells.
+var ErrNotEnoughCells = errors.New("shard: not enough cells in the lattice")
+
+// ErrNoEndpoints is returned when a cell picked for a shard has no
+// endpoints, which happens with sparse lattices.
+var ErrNoEndpoints = errors.New("shard: no endpoints available")
+
+// ErrNotEnoughEndpoints is returned when a cell picked for a shard has
+// fewer endpoints than required, or fewer which are not in the shard yet.
+var ErrNotEnoughEndpoints = errors.New("shard: not enough endpoints")
+
+// CellCapacity returns the largest number of cells a simple shuffle shard
+// of the lattice can span. Cells of a shard never share the value of any
+// dimension, so this is the size of the smallest dimension; except for one
+// dimensional lattices, where every cell can be part of a shard.
+func (l *Lattice) CellCapacity() int {
+	return newShardTable(l).capacity
+}
+
 // SimpleShuffleShard implementation uses simple probabilistic hashing to
 // compute shuffle shards. This function takes an existing lattice and
 // generates a new sharded lattice for the given indentification and
 // required number of endpoints with the sharded endpoints.
 func (l *Lattice) SimpleShuffleShard(id []byte, epc int) (*Lattice, error) {
+	m, start := loadMetrics(), time.Now()
+
+	t := newShardTable(l)
+	shard, err := t.shard(rand.New(rand.NewSource(0)), id, epc, t.capacity)
+	if m != nil {
+		observeShard(m, "simple", start, err)
+	}
+
+	return shard, err
+}
+
+// SimpleShuffleShardCells is like SimpleShuffleShard, but the shard spans
+// the given number of cells instead of as many cells as the lattice allows.
+// The number of cells must be between one and the cell capacity of the
+// lattice (see CellCapacity), otherwise ErrNotEnoughCells is returned. The
+// cells of a shard are a prefix of the cells picked by SimpleShuffleShard
+// for the same identification.
+func (l *Lattice) SimpleShuffleShardCells(id []byte, epc, cells int) (
+	*Lattice, error,
+) {
+	var (
+		m, start = loadMetrics(), time.Now()
+		shard    *Lattice
+		err      error
+	)
+
+	if cells < 1 {
+		err = fmt.Errorf("%w: %d cells requested", ErrNotEnoughCells, cells)
+	} else {
+		t := newShardTable(l)
+		shard, err = t.shard(rand.New(rand.NewSource(0)), id, epc, cells)
+	}
+
+	if m != nil {
+		observeShard(m, "simple", start, err)
+	}
+
+	return shard, err
+}
+
+// shard computes the shuffle shard for an identification over the given
+// number of cells, see SimpleShuffleShardCells. The random generator is
+// re-seeded for the identification, which lets callers reuse it.
+func (t *shardTable) shard(r *rand.Rand, id []byte, epc, cells int) (
+	*Lattice, error,
+) {
 	var (
-		r       *rand.Rand
 		shdSeed int64
 
 		shuffled [][]string
 		eps      []string
 		coords   []string
-		minDim   int64
 		dimVals  []string
-		dimC     map[string]int
 		shard    *Lattice
 
+		// Endpoints already in the shard; an endpoint may be in several
+		// cells of a lattice, but never twice in a shard.
+		chosen = map[string]bool{}
+
 		err error
 	)
 
-	// Create a seed a random generator.
-	shdSeed = int64(murmur3.Sum64WithSeed(id, uint32(l.Seed)))
-	r = rand.New(rand.NewSource(l.Seed * shdSeed * 42))
-
-	// The "chosen" lattice, which will have the sharded endpoints.
-	shard, err = NewLatticeWithSeed(l.Seed, l.GetDimensionNames())
-	if err != nil {
+	if epc < 0 {
+		return nil, fmt.Errorf(
+			"shard: negative number of endpoints per cell: %d", epc,
+		)
+	} else if cells > t.capacity {
 		return nil, fmt.Errorf(
-			"shard: unable to create a sharded lattice: %v", err,
+			"%w: %d cells requested, the lattice has room for %d",
+			ErrNotEnoughCells, cells, t.capacity,
 		)
 	}
 
+	// Create a seed a random generator.
+	shdSeed = int64(murmur3.Sum64WithSeed(id, uint32(t.seed)))
+	r.Seed(t.seed * shdSeed * 42)
+
+	// The "chosen" lattice, which will have the sharded endpoints, with
+	// the dimensions in the order of the lattice. The names are copied
+	// since the table may be shared between goroutines.
+	shard = newLattice(t.seed, t.names)
+
 	// Shuffle the order of the values in each dimension.
 	shuffled = [][]string{}
-	for _, d := range l.GetDimensionNames() {
-		dimVals = l.GetDimensionValues(d)
+	for _, v := range t.values {
+		dimVals = append([]string{}, v...)
 		r.Shuffle(len(dimVals), func(x, y int) {
 			dimVals[x], dimVals[y] = dimVals[y], dimVals[x]
 		})
 		shuffled = append(shuffled, dimVals)
 	}
 
-	// Get the dimensionality of the lattice.
-	dimC = l.GetDimensionality()
-
 	// One dimensional lattices are a special case. For a one dimensional
 	// lattice, we select end-points from each cell, since there is no other
 	// dimension to consider.
-	if len(dimC) == 1 {
-		for _, dimVal := range shuffled[0] {
-			eps, err = l.GetEndpointsForSector([]string{dimVal})
+	if len(t.names) == 1 {
+		for _, dimVal := range shuffled[0][:cells] {
+			eps, err = t.pick(r, []string{dimVal}, epc, chosen)
 			if err != nil {
 				return nil, err
 			}
 
-			r.Shuffle(len(eps), func(x, y int) {
-				eps[x], eps[y] = eps[y], eps[x]
-			})
-			err = shard.AddEndpointsForSector([]string{dimVal}, eps[:epc])
+			err = shard.AddEndpointsForSector([]string{dimVal}, eps)
 			if err != nil {
 				return nil, fmt.Errorf(
 					"shard: unable to add endpoints: %v", err,
@@ -83,38 +190,131 @@ func (l *Lattice) SimpleShuffleShard(id []byte, epc int) (*Lattice, error) {
 		return shard, nil
 	}
 
-	// Otherwise, this is a multi-dimensional lattice.
-	minDim = math.MaxInt64
+	// Otherwise, this is a multi-dimensional lattice. Build a coordinate
+	// to the chosen cells by picking the current top item on each list of
+	// dimension values.
+	for i := 0; i < cells; i++ {
+		coords = []string{}
+		for j := 0; j < len(t.names); j++ {
+			coords = append(coords, shuffled[j][0])
+			shuffled[j] = shuffled[j][1:]
+		}
 
-	// Which dimension has the smallest number of values in it?
-	for _, v := range dimC {
-		if int64(v) < minDim {
-			minDim = int64(v)
+		eps, err = t.pick(r, coords, epc, chosen)
+		if err != nil {
+			return nil, err
 		}
+
+		shard.AddEndpointsForSector(coords, eps)
 	}
 
-	// Build a coordinate to the chosen cells by picking the current top
-	// item on each list of dimension values.
-	for i := int64(0); i < minDim; i++ {
-		coords = []string{}
-		for j := 0; j < len(l.GetDimensionNames()); j++ {
-			coords = append(coords, shuffled[j][0])
-			shuffled[j] = shuffled[j][1:]
+	return shard, nil
+}
+
+// pick shuffles the endpoints of a cell and returns the first epc of them
+// which are not chosen yet, marking them as chosen.
+func (t *shardTable) pick(
+	r *rand.Rand, sec []string, epc int, chosen map[string]bool,
+) ([]string, error) {
+	var eps []string
+	if c := t.cells.find(sec); c != nil {
+		eps = t.cells.endpoints(c)
+	}
+
+	if len(eps) <= 0 {
+		return nil, ErrNoEndpoints
+	} else if len(eps) < epc {
+		return nil, fmt.Errorf(
+			"%w in sector %v: %d < %d",
+			ErrNotEnoughEndpoints, sec, len(eps), epc,
+		)
+	}
+
+	// Shuffle a copy, the lattice must not be reordered.
+	eps = append([]string{}, eps...)
+	r.Shuffle(len(eps), func(x, y int) {
+		eps[x], eps[y] = eps[y], eps[x]
+	})
+
+	// Skip the endpoints the shard has from other cells.
+	n := 0
+	for _, e := range eps {
+		if n == epc {
+			break
+		} else if !chosen[e] {
+			chosen[e] = true
+			eps[n] = e
+			n++
 		}
+	}
 
-		eps, err = l.GetEndpointsForSector(coords)
+	if n < epc {
+		return nil, fmt.Errorf(
+			"%w in sector %v: %d distinct < %d",
+			ErrNotEnoughEndpoints, sec, n, epc,
+		)
+	}
+
+	return eps[:epc], nil
+}
+
+// SimpleShuffleShardEndpoints returns the endpoints of the shard computed by
+// SimpleShuffleShard for the given identification, in a priority order that
+// is deterministic for it. The order interleaves the cells of the shard, so
+// consecutive endpoints lie in different cells (and failure domains) where
+// possible. It is intended for clients which retry requests within a shard.
+func (l *Lattice) SimpleShuffleShardEndpoints(id []byte, epc int) (
+	[]string, error,
+) {
+	var (
+		r      *rand.Rand
+		shard  *Lattice
+		coords [][]string
+		cells  [][]string
+		eps    []string
+		order  []string
+
+		err error
+	)
+
+	shard, err = l.SimpleShuffleShard(id, epc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use a different seed than the one used for sharding, so that the
+	// order does not follow the order in which endpoints were picked.
+	r = rand.New(rand.NewSource(
+		int64(murmur3.Sum64WithSeed(id, uint32(l.Seed)^orderSalt)),
+	))
+
+	// Shuffle the order of the cells, and of the endpoints in each cell.
+	coords = shard.GetAllCoordinates()
+	r.Shuffle(len(coords), func(x, y int) {
+		coords[x], coords[y] = coords[y], coords[x]
+	})
+
+	for _, c := range coords {
+		eps, err = shard.GetEndpointsForSector(c)
 		if err != nil {
 			return nil, fmt.Errorf("shard: unable to get endpoints: %v", err)
-		} else if len(eps) <= 0 {
-			return nil, fmt.Errorf("shard: no endpoints available")
 		}
 
+		eps = append([]string{}, eps...)
 		r.Shuffle(len(eps), func(x, y int) {
 			eps[x], eps[y] = eps[y], eps[x]
 		})
+		cells = append(cells, eps)
+	}
 
-		shard.AddEndpointsForSector(coords, eps[:epc])
+	// Take one endpoint from each cell in turn.
+	for i := 0; i < epc; i++ {
+		for _, eps = range cells {
+			if i < len(eps) {
+				order = append(order, eps[i])
+			}
+		}
 	}
 
-	return shard, nil
+	return order, nil
 }
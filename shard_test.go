@@ -1,6 +1,7 @@
 package shuffle_test
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"testing"
@@ -54,7 +55,7 @@ func TestSimpleShuffleShard(t *testing.T) {
 	lat.AddEndpointsForSector([]string{"x"}, eps)
 
 	for i := 0; i < 100000; i++ {
-		shd, err = lat.SimpleShuffleShard([]byte{byte(i)}, 4)
+		shd, err = lat.SimpleShuffleShard([]byte(fmt.Sprintf("%d", i)), 4)
 		if err != nil {
 			t.Fatalf("unable to shard the lattice: %v", err)
 		}
@@ -120,7 +121,7 @@ func TestSimpleShuffleShard(t *testing.T) {
 	lat.AddEndpointsForSector([]string{"us-y"}, eps[len(eps)/2:])
 
 	for i := 0; i < 100000; i++ {
-		shd, err = lat.SimpleShuffleShard([]byte{byte(i)}, 2)
+		shd, err = lat.SimpleShuffleShard([]byte(fmt.Sprintf("%d", i)), 2)
 		if err != nil {
 			t.Fatalf("unable to shard the lattice: %v", err)
 		}
@@ -188,7 +189,7 @@ func TestSimpleShuffleShard(t *testing.T) {
 	lat.AddEndpointsForSector([]string{"y", "2"}, eps[3*len(eps)/4:])
 
 	for i := 0; i < 100000; i++ {
-		shd, err = lat.SimpleShuffleShard([]byte{byte(i)}, 2)
+		shd, err = lat.SimpleShuffleShard([]byte(fmt.Sprintf("%d", i)), 2)
 
 		if err != nil {
 			t.Fatalf("unable to shard the lattice: %v", err)
@@ -326,7 +327,7 @@ func TestSimpleShuffleShard(t *testing.T) {
 	lat.AddEndpointsForSector([]string{"y", "3"}, eps[5*len(eps)/6:])
 
 	for i := 0; i < 100000; i++ {
-		shd, err = lat.SimpleShuffleShard([]byte{byte(i)}, 2)
+		shd, err = lat.SimpleShuffleShard([]byte(fmt.Sprintf("%d", i)), 2)
 
 		if err != nil {
 			t.Fatalf("unable to shard the lattice: %v", err)
@@ -468,3 +469,262 @@ func TestSimpleShuffleShard(t *testing.T) {
 		}
 	}
 }
+
+// TestSimpleShuffleShardEndpoints checks the priority order of the endpoints
+// in a shard.
+func TestSimpleShuffleShardEndpoints(t *testing.T) {
+	var (
+		lat   *shuffle.Lattice
+		shd   *shuffle.Lattice
+		order []string
+		again []string
+		cell  map[string]string
+		first map[string]int
+		err   error
+	)
+
+	lat, err = shuffle.NewLattice([]string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+
+	cell = map[string]string{}
+	for _, az := range []string{"x", "y", "z"} {
+		for _, v := range []string{"1", "2", "3"} {
+			var eps []string
+			for i := 0; i < 4; i++ {
+				ep := fmt.Sprintf("%s%s-%d", az, v, i)
+				cell[ep] = az + v
+				eps = append(eps, ep)
+			}
+			lat.AddEndpointsForSector([]string{az, v}, eps)
+		}
+	}
+
+	first = map[string]int{}
+	for i := 0; i < 1000; i++ {
+		id := []byte(fmt.Sprintf("%d", i))
+
+		order, err = lat.SimpleShuffleShardEndpoints(id, 2)
+		if err != nil {
+			t.Fatalf("unable to order the shard: %v", err)
+		}
+
+		// The order is deterministic.
+		again, err = lat.SimpleShuffleShardEndpoints(id, 2)
+		if err != nil {
+			t.Fatalf("unable to order the shard: %v", err)
+		}
+		if fmt.Sprint(order) != fmt.Sprint(again) {
+			t.Fatalf(
+				"the order is not deterministic: %v != %v", order, again,
+			)
+		}
+
+		// It holds the endpoints of the shard.
+		shd, err = lat.SimpleShuffleShard(id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		if len(order) != len(shd.GetAllEndpoints()) {
+			t.Fatalf(
+				"illegal number of endpoints returned: expected %d, "+
+					"but got: %d", len(shd.GetAllEndpoints()), len(order),
+			)
+		}
+		for _, ep := range order {
+			if !contains(ep, shd.GetAllEndpoints()) {
+				t.Fatalf("endpoint %s is not part of the shard", ep)
+			}
+		}
+
+		// Consecutive endpoints are in different cells.
+		for j := 1; j < len(order); j++ {
+			if cell[order[j]] == cell[order[j-1]] {
+				t.Fatalf(
+					"consecutive endpoints in the same cell: %v", order,
+				)
+			}
+		}
+
+		first[order[0]]++
+	}
+
+	// Tenants do not all start with the same endpoint: 1000 tenants over
+	// 36 endpoints should see most of them first.
+	if len(first) < 30 {
+		t.Fatalf(
+			"the first endpoint is poorly distributed: %v", first,
+		)
+	}
+}
+
+// TestSimpleShuffleShardCells tests sharding over a given number of cells.
+func TestSimpleShuffleShardCells(t *testing.T) {
+	lat, err := shuffle.NewLattice([]string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+
+	for _, az := range []string{"x", "y", "z"} {
+		for _, v := range []string{"1", "2", "3", "4"} {
+			lat.AddEndpointsForSector(
+				[]string{az, v}, []string{az + v + "-a", az + v + "-b"},
+			)
+		}
+	}
+
+	if lat.CellCapacity() != 3 {
+		t.Fatalf(
+			"illegal cell capacity returned: expected 3, but got: %d",
+			lat.CellCapacity(),
+		)
+	}
+
+	for i := 0; i < 100; i++ {
+		id := []byte(fmt.Sprintf("%d", i))
+
+		full, err := lat.SimpleShuffleShard(id, 1)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		for cells := 1; cells <= 3; cells++ {
+			shd, err := lat.SimpleShuffleShardCells(id, 1, cells)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			if len(shd.GetAllCoordinates()) != cells {
+				t.Fatalf(
+					"illegal number of coordinates returned: expected %d, "+
+						"but got: %d", cells, len(shd.GetAllCoordinates()),
+				)
+			}
+
+			// The cells are the first ones of the full shard.
+			for _, ep := range shd.GetAllEndpoints() {
+				if !contains(ep, full.GetAllEndpoints()) {
+					t.Fatalf(
+						"bad endpoints returned: expected one of %v, "+
+							"but got: %s", full.GetAllEndpoints(), ep,
+					)
+				}
+			}
+		}
+	}
+
+	for _, cells := range []int{0, 4} {
+		_, err = lat.SimpleShuffleShardCells([]byte("foo"), 1, cells)
+		if !errors.Is(err, shuffle.ErrNotEnoughCells) {
+			t.Fatalf(
+				"expected ErrNotEnoughCells for %d cells, but got: %v",
+				cells, err,
+			)
+		}
+	}
+
+	// A negative number of endpoints per cell is an error, not a panic.
+	if _, err = lat.SimpleShuffleShard([]byte("foo"), -1); err == nil {
+		t.Fatalf("expected error for a negative number of endpoints")
+	}
+	if _, err = lat.SimpleShuffleShardCells([]byte("foo"), -1, 2); err == nil {
+		t.Fatalf("expected error for a negative number of endpoints")
+	}
+
+	// One dimensional lattices can use every cell.
+	one, err := shuffle.NewLattice([]string{"az"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+	for _, az := range []string{"x", "y", "z", "w"} {
+		one.AddEndpointsForSector([]string{az}, []string{az + "-a"})
+	}
+
+	if one.CellCapacity() != 4 {
+		t.Fatalf(
+			"illegal cell capacity returned: expected 4, but got: %d",
+			one.CellCapacity(),
+		)
+	}
+
+	shd, err := one.SimpleShuffleShardCells([]byte("foo"), 1, 2)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+	if len(shd.GetAllEndpoints()) != 2 {
+		t.Fatalf(
+			"illegal number of endpoints returned: expected 2, "+
+				"but got: %d", len(shd.GetAllEndpoints()),
+		)
+	}
+}
+
+// TestSimpleShuffleShardDuplicates checks that endpoints which are in
+// several cells of a lattice are never picked twice for a shard.
+func TestSimpleShuffleShardDuplicates(t *testing.T) {
+	lat, err := shuffle.NewLatticeWithSeed(42, []string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a new lattice: %v", err)
+	}
+
+	// Every endpoint is in two cells.
+	for i, az := range []string{"x", "y", "z"} {
+		for j, v := range []string{"1", "2", "3"} {
+			lat.AddEndpointsForSector([]string{az, v}, []string{
+				fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", j),
+				fmt.Sprintf("c%d", (i+j)%3),
+			})
+		}
+	}
+
+	c, err := lat.Compile()
+	if err != nil {
+		t.Fatalf("unable to compile the lattice: %v", err)
+	}
+	dst := make([]string, c.ShardSize(2))
+
+	for i := 0; i < 100; i++ {
+		id := []byte(fmt.Sprintf("%d", i))
+
+		for _, f := range []func([]byte, int) (*shuffle.Lattice, error){
+			lat.SimpleShuffleShard, lat.RendezvousShuffleShard,
+		} {
+			shd, err := f(id, 2)
+			if err != nil {
+				t.Fatalf("unable to shard the lattice: %v", err)
+			}
+
+			n := 0
+			for _, sec := range shd.GetAllCoordinates() {
+				e, _ := shd.GetEndpointsForSector(sec)
+				n += len(e)
+			}
+			if n != 6 || len(shd.GetAllEndpoints()) != 6 {
+				t.Fatalf(
+					"endpoints picked twice: %v", shd.EndpointsByCoordinate,
+				)
+			}
+		}
+
+		n, err := c.ShardInto(dst, id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		shd, _ := lat.SimpleShuffleShard(id, 2)
+		for _, ep := range dst[:n] {
+			if !contains(ep, shd.GetAllEndpoints()) {
+				t.Fatalf(
+					"compiled shard differs: expected one of %v, but "+
+						"got: %s", shd.GetAllEndpoints(), ep,
+				)
+			}
+		}
+	}
+
+	// Cells may run out of distinct endpoints.
+	if _, err = lat.SimpleShuffleShard([]byte("foo"), 3); err == nil {
+		t.Fatalf("expected an error for too few distinct endpoints")
+	}
+}
@@ -0,0 +1,121 @@
+package simulation
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// PoisonConfig describes a poison tenant simulation: in each trial, some
+// tenants send requests which take down every endpoint of their shards,
+// and the other tenants lose those endpoints too.
+type PoisonConfig struct {
+	// Lattice holds the endpoints, Strategy assigns them to the tenants.
+	Lattice  *shuffle.Lattice
+	Strategy Strategy
+	Tenants  int
+
+	// Poison is the number of poison tenants, picked at random in each of
+	// the trials.
+	Poison int
+	Trials int
+
+	// Seed drives the strategy and the choice of the poison tenants.
+	Seed int64
+}
+
+// PoisonReport is the result of a poison tenant simulation. Tenants are
+// fully impacted when a poison tenant took down all the endpoints of their
+// shard, and partially impacted when it took down some of them; poison
+// tenants themselves are not counted.
+type PoisonReport struct {
+	Trials  int
+	Tenants int
+	Poison  int
+
+	// Mean number of other tenants impacted per trial, and the same as a
+	// fraction of the other tenants.
+	FullyImpacted             float64
+	PartiallyImpacted         float64
+	FullyImpactedFraction     float64
+	PartiallyImpactedFraction float64
+
+	// Largest number of other tenants impacted in a trial.
+	MaxFullyImpacted     int
+	MaxPartiallyImpacted int
+}
+
+// RunPoison assigns shards to the tenants, and runs the trials.
+func RunPoison(c PoisonConfig) (*PoisonReport, error) {
+	if c.Lattice == nil || c.Strategy == nil {
+		return nil, fmt.Errorf(
+			"simulation: a lattice and a strategy are required",
+		)
+	} else if c.Poison < 1 || c.Poison >= c.Tenants {
+		return nil, fmt.Errorf(
+			"simulation: there must be poison tenants, and others",
+		)
+	} else if c.Trials < 1 {
+		return nil, fmt.Errorf("simulation: at least one trial is required")
+	}
+
+	shards, err := c.Strategy(c.Lattice, c.Tenants, c.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		r   = rand.New(rand.NewSource(c.Seed))
+		rep = &PoisonReport{
+			Trials: c.Trials, Tenants: c.Tenants, Poison: c.Poison,
+		}
+		eps = endpoints(shards)
+	)
+
+	for t := 0; t < c.Trials; t++ {
+		var (
+			poison = map[int]bool{}
+			down   = map[string]bool{}
+			full   int
+			part   int
+		)
+
+		for _, i := range r.Perm(c.Tenants)[:c.Poison] {
+			poison[i] = true
+			for _, ep := range eps[i] {
+				down[ep] = true
+			}
+		}
+
+		for i, e := range eps {
+			if poison[i] {
+				continue
+			}
+
+			switch a := availability(e, down); {
+			case a == 0:
+				full++
+			case a < 1:
+				part++
+			}
+		}
+
+		rep.FullyImpacted += float64(full)
+		rep.PartiallyImpacted += float64(part)
+		if full > rep.MaxFullyImpacted {
+			rep.MaxFullyImpacted = full
+		}
+		if part > rep.MaxPartiallyImpacted {
+			rep.MaxPartiallyImpacted = part
+		}
+	}
+
+	others := float64(c.Tenants - c.Poison)
+	rep.FullyImpacted /= float64(c.Trials)
+	rep.PartiallyImpacted /= float64(c.Trials)
+	rep.FullyImpactedFraction = rep.FullyImpacted / others
+	rep.PartiallyImpactedFraction = rep.PartiallyImpacted / others
+
+	return rep, nil
+}
@@ -0,0 +1,77 @@
+package simulation_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard/simulation"
+)
+
+// TestRunPoison compares the impact of poison tenants on simple and
+// stateful shards.
+func TestRunPoison(t *testing.T) {
+	l := lattice(t)
+
+	c := simulation.PoisonConfig{
+		Lattice: l,
+		Tenants: 30,
+		Poison:  1,
+		Trials:  100,
+		Seed:    7,
+	}
+
+	c.Strategy = simulation.Simple(1)
+	simple, err := simulation.RunPoison(c)
+	if err != nil {
+		t.Fatalf("unable to run the simulation: %v", err)
+	}
+
+	// Stateful shards of three endpoints share at most one endpoint, a
+	// poison tenant cannot take down another tenant.
+	c.Strategy = simulation.Stateful(1, 1)
+	stateful, err := simulation.RunPoison(c)
+	if err != nil {
+		t.Fatalf("unable to run the simulation: %v", err)
+	}
+
+	if stateful.FullyImpacted != 0 || stateful.MaxFullyImpacted != 0 {
+		t.Fatalf("stateful tenants were fully impacted: %+v", stateful)
+	}
+
+	for _, rep := range []*simulation.PoisonReport{simple, stateful} {
+		if rep.Trials != 100 || rep.Tenants != 30 || rep.Poison != 1 ||
+			rep.PartiallyImpacted <= 0 ||
+			rep.FullyImpacted+rep.PartiallyImpacted > 29 ||
+			float64(rep.MaxPartiallyImpacted) < rep.PartiallyImpacted ||
+			rep.PartiallyImpactedFraction != rep.PartiallyImpacted/29 {
+			t.Fatalf("illegal report: %+v", rep)
+		}
+	}
+
+	// Simulations are deterministic.
+	again, err := simulation.RunPoison(c)
+	if err != nil {
+		t.Fatalf("unable to run the simulation: %v", err)
+	}
+	if !reflect.DeepEqual(stateful, again) {
+		t.Fatalf("reports differ: %+v, %+v", stateful, again)
+	}
+
+	// More poison tenants do more harm.
+	c.Poison = 5
+	worse, err := simulation.RunPoison(c)
+	if err != nil {
+		t.Fatalf("unable to run the simulation: %v", err)
+	}
+	if worse.FullyImpacted+worse.PartiallyImpacted <=
+		stateful.FullyImpacted+stateful.PartiallyImpacted {
+		t.Fatalf("illegal report: %+v", worse)
+	}
+
+	for _, p := range []int{0, 30} {
+		c.Poison = p
+		if _, err = simulation.RunPoison(c); err == nil {
+			t.Fatalf("expected an error for %d poison tenants", p)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Package simulation compares shuffle sharding strategies under failures.
+// A simulation assigns a shard of a lattice to each tenant, and then runs
+// trials in which endpoints fail, according to a failure model. It reports
+// how available the endpoints of the tenants remained; RunPoison reports
+// instead how many tenants lost endpoints to poison tenants. Simulations
+// are deterministic given their seed (and the seed of the lattice).
+package simulation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// Strategy assigns a shard of a lattice to each of the given number of
+// tenants. The seed drives the choices of strategies which need one.
+type Strategy func(l *shuffle.Lattice, tenants int, seed int64) (
+	[]*shuffle.Lattice, error,
+)
+
+// TenantID returns the identification of the nth tenant of a simulation.
+func TenantID(n int) []byte {
+	return []byte(fmt.Sprintf("tenant-%d", n))
+}
+
+// Simple is the strategy of SimpleShuffleShard, with epc endpoints per
+// cell. It does not depend on the seed of the simulation, but on the seed
+// of the lattice.
+func Simple(epc int) Strategy {
+	return func(l *shuffle.Lattice, tenants int, _ int64) (
+		[]*shuffle.Lattice, error,
+	) {
+		shards := make([]*shuffle.Lattice, tenants)
+		for i := range shards {
+			shd, err := l.SimpleShuffleShard(TenantID(i), epc)
+			if err != nil {
+				return nil, fmt.Errorf(
+					"simulation: unable to shard tenant %d: %v", i, err,
+				)
+			}
+			shards[i] = shd
+		}
+
+		return shards, nil
+	}
+}
+
+// Stateful is the strategy of StatefulShuffleShard, with epc endpoints per
+// cell and the given maximum overlap, allocated in the order of the tenants
+// by a sharder seeded with the seed of the simulation. It fails if the
+// lattice runs out of shards before every tenant has one.
+func Stateful(epc, maximumOverlap int) Strategy {
+	return func(l *shuffle.Lattice, tenants int, seed int64) (
+		[]*shuffle.Lattice, error,
+	) {
+		sharder := shuffle.NewStatefulSharderWithSeed(seed)
+		shards := make([]*shuffle.Lattice, tenants)
+		for i := range shards {
+			shd, err := sharder.StatefulShuffleShard(l, epc, maximumOverlap)
+			if errors.Is(err, shuffle.ErrNoShards) {
+				return nil, fmt.Errorf(
+					"simulation: only %d of %d tenants have a shard: %w",
+					i, tenants, err,
+				)
+			} else if err != nil {
+				return nil, fmt.Errorf(
+					"simulation: unable to shard tenant %d: %v", i, err,
+				)
+			}
+			shards[i] = shd
+		}
+
+		return shards, nil
+	}
+}
+
+// FailureModel returns the endpoints of a lattice which fail in a trial,
+// making its choices with r.
+type FailureModel func(l *shuffle.Lattice, r *rand.Rand) (
+	map[string]bool, error,
+)
+
+// RandomEndpointLoss fails each endpoint independently, with probability p.
+func RandomEndpointLoss(p float64) FailureModel {
+	return func(l *shuffle.Lattice, r *rand.Rand) (map[string]bool, error) {
+		failed := map[string]bool{}
+		for _, ep := range l.GetAllEndpoints() {
+			if r.Float64() < p {
+				failed[ep] = true
+			}
+		}
+
+		return failed, nil
+	}
+}
+
+// DimensionLoss fails n values of a dimension, picked at random, with all
+// of their endpoints; see SimulateFailure. Losing a whole availability zone
+// is DimensionLoss("az", 1).
+func DimensionLoss(dimension string, n int) FailureModel {
+	return func(l *shuffle.Lattice, r *rand.Rand) (map[string]bool, error) {
+		var (
+			values = l.GetDimensionValues(dimension)
+			left   = l
+			err    error
+		)
+
+		if indexOf(l.GetDimensionNames(), dimension) < 0 {
+			return nil, fmt.Errorf(
+				"simulation: unknown dimension %q", dimension,
+			)
+		} else if n > len(values) {
+			return nil, fmt.Errorf(
+				"simulation: dimension %q has fewer than %d values",
+				dimension, n,
+			)
+		}
+
+		for _, i := range r.Perm(len(values))[:n] {
+			left, err = left.SimulateFailure(dimension, values[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return lost(l, left.GetAllEndpoints()), nil
+	}
+}
+
+// RackLoss fails n racks, picked at random, with all of their endpoints.
+// Racks need not be a dimension of the lattice: they are given as the
+// endpoints of each rack. Endpoints of the racks which are not in the
+// lattice are ignored.
+func RackLoss(racks map[string][]string, n int) FailureModel {
+	var names []string
+	for rack := range racks {
+		names = append(names, rack)
+	}
+	sort.Strings(names)
+
+	return func(l *shuffle.Lattice, r *rand.Rand) (map[string]bool, error) {
+		if n > len(names) {
+			return nil, fmt.Errorf("simulation: fewer than %d racks", n)
+		}
+
+		failed := map[string]bool{}
+		for _, i := range r.Perm(len(names))[:n] {
+			for _, ep := range racks[names[i]] {
+				failed[ep] = true
+			}
+		}
+
+		return failed, nil
+	}
+}
+
+// lost returns the endpoints of a lattice which are not left.
+func lost(l *shuffle.Lattice, left []string) map[string]bool {
+	failed := map[string]bool{}
+	for _, ep := range l.GetAllEndpoints() {
+		failed[ep] = true
+	}
+	for _, ep := range left {
+		delete(failed, ep)
+	}
+
+	return failed
+}
+
+// indexOf returns the index of a string in a slice, or -1.
+func indexOf(s []string, e string) int {
+	for i := range s {
+		if s[i] == e {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// DefaultPercentiles are the percentiles of availability a simulation
+// reports by default.
+var DefaultPercentiles = []float64{0, 0.1, 1, 5, 10, 50}
+
+// Config describes a simulation.
+type Config struct {
+	// Lattice holds the endpoints, Strategy assigns them to the tenants.
+	Lattice  *shuffle.Lattice
+	Strategy Strategy
+	Tenants  int
+
+	// Failure picks the endpoints which fail in each of the trials.
+	Failure FailureModel
+	Trials  int
+
+	// Seed drives the strategy and the failures.
+	Seed int64
+
+	// Percentiles of availability to report, between 0 and 100;
+	// DefaultPercentiles if nil.
+	Percentiles []float64
+}
+
+// Percentile is an availability percentile: P percent of the tenants had
+// at most Availability in a trial.
+type Percentile struct {
+	P            float64
+	Availability float64
+}
+
+// Report is the result of a simulation. The availability of a tenant in a
+// trial is the fraction of the endpoints of its shard which did not fail;
+// statistics are over every tenant of every trial.
+type Report struct {
+	Trials  int
+	Tenants int
+
+	// Mean availability, and its percentiles.
+	Mean        float64
+	Percentiles []Percentile
+
+	// Outages counts the tenants which had no endpoint left in a trial,
+	// and OutageFraction is the same as a fraction of all tenants.
+	Outages        int
+	OutageFraction float64
+
+	// FailedEndpoints is the mean number of endpoints failed per trial.
+	FailedEndpoints float64
+}
+
+// availability returns the fraction of the endpoints of a shard which did
+// not fail.
+func availability(eps []string, failed map[string]bool) float64 {
+	if len(eps) == 0 {
+		return 0
+	}
+
+	up := 0
+	for _, ep := range eps {
+		if !failed[ep] {
+			up++
+		}
+	}
+
+	return float64(up) / float64(len(eps))
+}
+
+// endpoints returns the endpoints of each shard.
+func endpoints(shards []*shuffle.Lattice) [][]string {
+	eps := make([][]string, len(shards))
+	for i, shd := range shards {
+		eps[i] = shd.GetAllEndpoints()
+	}
+
+	return eps
+}
+
+// percentile returns the pth percentile (nearest rank) of sorted samples.
+func percentile(sorted []float64, p float64) float64 {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+
+	return sorted[i]
+}
+
+// Run assigns shards to the tenants, and runs the trials.
+func Run(c Config) (*Report, error) {
+	if c.Lattice == nil || c.Strategy == nil || c.Failure == nil {
+		return nil, fmt.Errorf(
+			"simulation: a lattice, a strategy and a failure model are " +
+				"required",
+		)
+	} else if c.Tenants < 1 || c.Trials < 1 {
+		return nil, fmt.Errorf(
+			"simulation: at least one tenant and one trial are required",
+		)
+	}
+
+	ps := c.Percentiles
+	if ps == nil {
+		ps = DefaultPercentiles
+	}
+	for _, p := range ps {
+		if p < 0 || p > 100 {
+			return nil, fmt.Errorf("simulation: bad percentile %v", p)
+		}
+	}
+
+	shards, err := c.Strategy(c.Lattice, c.Tenants, c.Seed)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		r       = rand.New(rand.NewSource(c.Seed))
+		rep     = &Report{Trials: c.Trials, Tenants: c.Tenants}
+		all     = c.Lattice.GetAllEndpoints()
+		eps     = endpoints(shards)
+		samples = make([]float64, 0, c.Trials*c.Tenants)
+		sum     float64
+	)
+
+	for t := 0; t < c.Trials; t++ {
+		failed, err := c.Failure(c.Lattice, r)
+		if err != nil {
+			return nil, err
+		}
+		for _, ep := range all {
+			if failed[ep] {
+				rep.FailedEndpoints++
+			}
+		}
+
+		for _, e := range eps {
+			a := availability(e, failed)
+			if a == 0 {
+				rep.Outages++
+			}
+			samples = append(samples, a)
+			sum += a
+		}
+	}
+
+	n := float64(len(samples))
+	rep.Mean = sum / n
+	rep.OutageFraction = float64(rep.Outages) / n
+	rep.FailedEndpoints /= float64(c.Trials)
+
+	sort.Float64s(samples)
+	for _, p := range ps {
+		rep.Percentiles = append(
+			rep.Percentiles, Percentile{p, percentile(samples, p)},
+		)
+	}
+
+	return rep, nil
+}
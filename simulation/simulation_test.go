@@ -0,0 +1,162 @@
+package simulation_test
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+	"github.com/clickyotomy/go-shuffle-shard/simulation"
+)
+
+// lattice returns a lattice of three zones and three versions, with four
+// endpoints per cell, named after their zone and version ("x1-0").
+func lattice(t *testing.T) *shuffle.Lattice {
+	l, err := shuffle.NewLatticeWithSeed(42, []string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	for _, az := range []string{"x", "y", "z"} {
+		for _, v := range []string{"1", "2", "3"} {
+			var eps []string
+			for i := 0; i < 4; i++ {
+				eps = append(eps, fmt.Sprintf("%s%s-%d", az, v, i))
+			}
+			l.AddEndpointsForSector([]string{az, v}, eps)
+		}
+	}
+
+	return l
+}
+
+// TestRun tests simulations with each failure model.
+func TestRun(t *testing.T) {
+	l := lattice(t)
+
+	racks := map[string][]string{}
+	for _, ep := range l.GetAllEndpoints() {
+		rack := "rack-" + ep[len(ep)-1:]
+		racks[rack] = append(racks[rack], ep)
+	}
+
+	for name, c := range map[string]simulation.Config{
+		"random": {
+			Strategy: simulation.Simple(1),
+			Failure:  simulation.RandomEndpointLoss(0.1),
+		},
+		"az": {
+			Strategy: simulation.Simple(2),
+			Failure:  simulation.DimensionLoss("az", 1),
+		},
+		"racks": {
+			Strategy: simulation.Stateful(1, 1),
+			Failure:  simulation.RackLoss(racks, 2),
+		},
+	} {
+		c.Lattice, c.Tenants, c.Trials, c.Seed = l, 20, 50, 7
+
+		rep, err := simulation.Run(c)
+		if err != nil {
+			t.Fatalf("%s: unable to run the simulation: %v", name, err)
+		}
+
+		if rep.Trials != 50 || rep.Tenants != 20 ||
+			rep.Mean <= 0 || rep.Mean >= 1 ||
+			len(rep.Percentiles) != len(simulation.DefaultPercentiles) {
+			t.Fatalf("%s: illegal report: %+v", name, rep)
+		}
+
+		for i := 1; i < len(rep.Percentiles); i++ {
+			if rep.Percentiles[i].Availability <
+				rep.Percentiles[i-1].Availability {
+				t.Fatalf("%s: percentiles out of order: %+v", name, rep)
+			}
+		}
+
+		// Simulations are deterministic.
+		again, err := simulation.Run(c)
+		if err != nil {
+			t.Fatalf("%s: unable to run the simulation: %v", name, err)
+		}
+		if !reflect.DeepEqual(rep, again) {
+			t.Fatalf("%s: reports differ: %+v, %+v", name, rep, again)
+		}
+
+		switch name {
+		case "az":
+			// A shard spans every zone, so it keeps two thirds of its
+			// endpoints, and nobody is down.
+			if rep.Mean < 0.66 || rep.Mean > 0.67 || rep.Outages != 0 ||
+				rep.FailedEndpoints != 12 {
+				t.Fatalf("%s: illegal report: %+v", name, rep)
+			}
+		case "racks":
+			if rep.FailedEndpoints != 18 {
+				t.Fatalf("%s: illegal report: %+v", name, rep)
+			}
+		}
+	}
+}
+
+// TestRunErrors tests the simulations which cannot run.
+func TestRunErrors(t *testing.T) {
+	l := lattice(t)
+
+	for name, c := range map[string]simulation.Config{
+		"no strategy": {
+			Failure: simulation.RandomEndpointLoss(0.1),
+		},
+		"unknown dimension": {
+			Strategy: simulation.Simple(1),
+			Failure:  simulation.DimensionLoss("rack", 1),
+		},
+		"too many zones": {
+			Strategy: simulation.Simple(1),
+			Failure:  simulation.DimensionLoss("az", 4),
+		},
+		"bad percentile": {
+			Strategy:    simulation.Simple(1),
+			Failure:     simulation.RandomEndpointLoss(0.1),
+			Percentiles: []float64{101},
+		},
+	} {
+		c.Lattice, c.Tenants, c.Trials = l, 1, 1
+		if _, err := simulation.Run(c); err == nil {
+			t.Fatalf("%s: expected an error", name)
+		}
+	}
+
+	// The lattice has room for few stateful shards this large.
+	_, err := simulation.Run(simulation.Config{
+		Lattice:  l,
+		Strategy: simulation.Stateful(4, 0),
+		Failure:  simulation.RandomEndpointLoss(0.1),
+		Tenants:  10,
+		Trials:   1,
+	})
+	if !errors.Is(err, shuffle.ErrNoShards) {
+		t.Fatalf("expected ErrNoShards, but got: %v", err)
+	}
+}
+
+// TestRandomEndpointLoss checks the failure probability.
+func TestRandomEndpointLoss(t *testing.T) {
+	l := lattice(t)
+	r := rand.New(rand.NewSource(1))
+
+	n := 0
+	for i := 0; i < 1000; i++ {
+		failed, err := simulation.RandomEndpointLoss(0.25)(l, r)
+		if err != nil {
+			t.Fatalf("unable to fail endpoints: %v", err)
+		}
+		n += len(failed)
+	}
+
+	if f := float64(n) / 36000; f < 0.24 || f > 0.26 {
+		t.Fatalf("illegal failure rate: expected 0.25, but got: %v", f)
+	}
+}
@@ -0,0 +1,115 @@
+package shuffle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// StatefulSnapshotVersion is the version of the snapshot format written by
+// StatefulSharder.Snapshot. Restoring other versions fails.
+const StatefulSnapshotVersion = 1
+
+// StatefulSnapshot is a point-in-time copy of the state of a
+// StatefulSharder, which can be encoded as JSON.
+type StatefulSnapshot struct {
+	Version int   `json:"version"`
+	Seed    int64 `json:"seed"`
+
+	// Parameters of the latest allocation.
+	EndpointsPerCell int `json:"endpointsPerCell"`
+	MaximumOverlap   int `json:"maximumOverlap"`
+
+	// Fragments taken by allocated shards, each sorted; and the shards
+	// assigned to tenants. Both are sorted, so that snapshots of the same
+	// state are identical.
+	Fragments   [][]string           `json:"fragments"`
+	Assignments []StatefulAssignment `json:"assignments"`
+}
+
+// StatefulAssignment is the shard assigned to a tenant.
+type StatefulAssignment struct {
+	ID    []byte   `json:"id"`
+	Shard *Lattice `json:"shard"`
+}
+
+// Snapshot takes a snapshot of the state of the sharder. The assigned
+// shards are shared with the sharder and must not be modified.
+func (shard *StatefulSharder) Snapshot() *StatefulSnapshot {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	snap := &StatefulSnapshot{
+		Version:          StatefulSnapshotVersion,
+		Seed:             shard.seed,
+		EndpointsPerCell: shard.endpointsPerCell,
+		MaximumOverlap:   shard.maximumOverlap,
+		Fragments:        [][]string{},
+		Assignments:      []StatefulAssignment{},
+	}
+
+	for _, fragment := range shard.store {
+		snap.Fragments = append(snap.Fragments, append([]string{}, fragment...))
+	}
+	sort.Slice(snap.Fragments, func(i, j int) bool {
+		return strings.Join(snap.Fragments[i], seperator) < strings.Join(snap.Fragments[j], seperator)
+	})
+
+	for id, assigned := range shard.assignments {
+		snap.Assignments = append(snap.Assignments, StatefulAssignment{[]byte(id), assigned})
+	}
+	sort.Slice(snap.Assignments, func(i, j int) bool {
+		return string(snap.Assignments[i].ID) < string(snap.Assignments[j].ID)
+	})
+
+	return snap
+}
+
+// WriteSnapshot writes a snapshot of the state of the sharder as JSON.
+func (shard *StatefulSharder) WriteSnapshot(w io.Writer) error {
+	return json.NewEncoder(w).Encode(shard.Snapshot())
+}
+
+// ReadStatefulSnapshot reads a snapshot written by WriteSnapshot.
+func ReadStatefulSnapshot(r io.Reader) (*StatefulSnapshot, error) {
+	snap := &StatefulSnapshot{}
+	if err := json.NewDecoder(r).Decode(snap); err != nil {
+		return nil, fmt.Errorf("snapshot: unable to decode: %v", err)
+	}
+
+	return snap, nil
+}
+
+// RestoreStatefulSharder creates a StatefulSharder with the state of a
+// snapshot. The random generator of the sharder starts over from the seed
+// of the snapshot.
+func RestoreStatefulSharder(snap *StatefulSnapshot) (*StatefulSharder, error) {
+	if snap.Version != StatefulSnapshotVersion {
+		return nil, fmt.Errorf("snapshot: unsupported version %d", snap.Version)
+	}
+
+	sharder := NewStatefulSharderWithSeed(snap.Seed)
+	sharder.endpointsPerCell = snap.EndpointsPerCell
+	sharder.maximumOverlap = snap.MaximumOverlap
+
+	for _, fragment := range snap.Fragments {
+		sharder.saveFragment(append([]string{}, fragment...))
+	}
+
+	for _, a := range snap.Assignments {
+		if a.Shard == nil {
+			return nil, fmt.Errorf("snapshot: no shard assigned to %q", a.ID)
+		}
+		// Shards decoded from JSON come without an index.
+		if a.Shard.cached() == nil {
+			if err := a.Shard.Reindex(); err != nil {
+				return nil, fmt.Errorf("snapshot: bad shard assigned to %q: %v", a.ID, err)
+			}
+		}
+		sharder.assignments[string(a.ID)] = a.Shard
+	}
+
+	return sharder, nil
+}
@@ -0,0 +1,92 @@
+package shuffle_test
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+func TestStatefulSnapshotRoundTrip(t *testing.T) {
+	lattice := batchLattice(t)
+
+	sharder := shuffle.NewStatefulSharderWithSeed(42)
+	for i := 0; i < 5; i++ {
+		if _, err := sharder.AssignShard([]byte(fmt.Sprintf("tenant-%d", i)), lattice, 2, 2); err != nil {
+			t.Fatalf("unable to assign a shard: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := sharder.WriteSnapshot(&buf); err != nil {
+		t.Fatalf("unable to write the snapshot: %v", err)
+	}
+
+	snap, err := shuffle.ReadStatefulSnapshot(&buf)
+	if err != nil {
+		t.Fatalf("unable to read the snapshot: %v", err)
+	}
+	if snap.Version != shuffle.StatefulSnapshotVersion || snap.Seed != 42 || snap.EndpointsPerCell != 2 || snap.MaximumOverlap != 2 {
+		t.Fatalf("Unexpected snapshot header: %+v", snap)
+	}
+
+	restored, err := shuffle.RestoreStatefulSharder(snap)
+	if err != nil {
+		t.Fatalf("unable to restore the snapshot: %v", err)
+	}
+
+	if restored.FragmentsUsed() != sharder.FragmentsUsed() {
+		t.Fatalf("Restored sharder has %d fragments, expected %d", restored.FragmentsUsed(), sharder.FragmentsUsed())
+	}
+
+	for i := 0; i < 5; i++ {
+		id := []byte(fmt.Sprintf("tenant-%d", i))
+		original, _ := sharder.Assignment(id)
+		assigned, ok := restored.Assignment(id)
+		if !ok {
+			t.Fatalf("Assignment of %s was not restored", id)
+		}
+		if !reflect.DeepEqual(assigned.GetAllCoordinates(), original.GetAllCoordinates()) ||
+			!reflect.DeepEqual(assigned.GetAllEndpoints(), original.GetAllEndpoints()) {
+			t.Fatalf("Assignment of %s differs after restoring", id)
+		}
+	}
+
+	var x, y bytes.Buffer
+	if err := sharder.WriteSnapshot(&x); err != nil {
+		t.Fatalf("unable to write the snapshot: %v", err)
+	}
+	if err := restored.WriteSnapshot(&y); err != nil {
+		t.Fatalf("unable to write the snapshot: %v", err)
+	}
+	if x.String() != y.String() {
+		t.Fatalf("Snapshots of the original and restored sharders differ")
+	}
+
+	snap.Version = 0
+	if _, err := shuffle.RestoreStatefulSharder(snap); err == nil {
+		t.Fatalf("Expected an error for an unsupported snapshot version")
+	}
+}
+
+func TestStatefulSharderWithSeed(t *testing.T) {
+	lattice := batchLattice(t)
+
+	a := shuffle.NewStatefulSharderWithSeed(7)
+	b := shuffle.NewStatefulSharderWithSeed(7)
+	for i := 0; i < 5; i++ {
+		x, err := a.StatefulShuffleShard(lattice, 2, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		y, err := b.StatefulShuffleShard(lattice, 2, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		if !reflect.DeepEqual(x.GetAllEndpoints(), y.GetAllEndpoints()) {
+			t.Fatalf("Sharders with the same seed allocated different shards")
+		}
+	}
+}
@@ -1,60 +1,224 @@
 package shuffle
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"math/rand"
 	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/getlantern/deepcopy"
 	"github.com/mxschmitt/golang-combinations"
 )
 
 type StatefulSharder struct {
-	store map[string]bool
+	mu          sync.Mutex
+	store       map[string][]string
+	assignments map[string]*Lattice
+
+	seed   int64
+	random *rand.Rand
+
+	// Parameters of the latest allocation, and the nodes its search explored.
+	endpointsPerCell int
+	maximumOverlap   int
+	nodes            int
+
+	warnAt   float64
+	warnHook func(StatefulCapacity)
+	observer func(StatefulEvent)
+
+	// The name of the sharder in the measurements, see SetName.
+	name string
 }
 
+// The number of sharders created, which names them.
+var sharders uint64
+
+// StatefulCapacity reports how much of the room for shards of a lattice a
+// StatefulSharder has used, for given shard parameters.
+type StatefulCapacity struct {
+	// FragmentsUsed is the number of fragments (sets of maximumOverlap+1
+	// endpoints) taken by the shards allocated so far.
+	FragmentsUsed int
+
+	// FragmentsTotal is the number of fragments in the lattice.
+	FragmentsTotal float64
+
+	// Used is the fraction of the fragments which are taken.
+	Used float64
+
+	// Remaining estimates the number of shards which can still be
+	// allocated: each shard takes all of its fragments, and fragments
+	// cannot be shared. It assumes that every shard spans as many cells as
+	// the lattice allows (see CellCapacity); shards of sparse lattices may
+	// span fewer cells and take fewer fragments, so more shards than
+	// estimated can be allocated.
+	Remaining float64
+}
+
+// ErrNoShards is returned when a StatefulSharder cannot allocate any more
+// shards without exceeding the maximum overlap.
+var ErrNoShards = errors.New("No shards available")
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// NewStatefulSharder creates a StatefulSharder which uses a seed based on
+// process start time. See NewStatefulSharderWithSeed.
 func NewStatefulSharder() *StatefulSharder {
+	return NewStatefulSharderWithSeed(seed)
+}
+
+// NewStatefulSharderWithSeed creates a StatefulSharder whose search for
+// shards is driven by the given seed, so that the same sequence of calls
+// allocates the same shards.
+func NewStatefulSharderWithSeed(seed int64) *StatefulSharder {
 	sharder := &StatefulSharder{}
-	sharder.store = map[string]bool{}
+	sharder.store = map[string][]string{}
+	sharder.assignments = map[string]*Lattice{}
+	sharder.seed = seed
+	sharder.random = rand.New(rand.NewSource(seed))
+	sharder.name = fmt.Sprintf("sharder-%d", atomic.AddUint64(&sharders, 1))
 	return sharder
 }
 
+// SetName names the sharder in the measurements reported to Metrics (see
+// SetMetrics), so that those of several sharders can be told apart. Every
+// sharder is given a name of its own when created, such as "sharder-1".
+func (shard *StatefulSharder) SetName(name string) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.name = name
+}
+
 func (shard *StatefulSharder) StatefulShuffleShard(lattice *Lattice, endpointsPerCell, maximumOverlap int) (*Lattice, error) {
-	targetLattice, err := shard.shuffleShardRecursiveHelper(lattice, endpointsPerCell, maximumOverlap)
+	shard.mu.Lock()
+	targetLattice, err := shard.allocate(lattice, endpointsPerCell, maximumOverlap)
+	shard.mu.Unlock()
+
 	if err != nil {
 		return nil, err
 	}
+	shard.checkCapacity(lattice, endpointsPerCell, maximumOverlap)
+	return targetLattice, nil
+}
 
-	if len(targetLattice.GetAllEndpoints()) == 0 {
-		return nil, fmt.Errorf("No shards available")
+// AssignShard returns the shard assigned to the tenant identified by id,
+// allocating one with StatefulShuffleShard on first use. Later calls for the
+// same id return the persisted assignment, as long as its endpoints are all
+// still in the same cells of the lattice; otherwise, such as after endpoints
+// were removed from the lattice, a new shard is allocated for the tenant.
+// The fragments of the replaced shard stay taken.
+func (shard *StatefulSharder) AssignShard(id []byte, lattice *Lattice, endpointsPerCell, maximumOverlap int) (*Lattice, error) {
+	shard.mu.Lock()
+	if assigned, ok := shard.assignments[string(id)]; ok && fits(assigned, lattice) {
+		shard.mu.Unlock()
+		return assigned, nil
 	}
 
-	for _, fragment := range combinations.Combinations(targetLattice.GetAllEndpoints(), maximumOverlap+1) {
-		shard.saveFragment(fragment)
+	assigned, err := shard.allocate(lattice, endpointsPerCell, maximumOverlap)
+	if err == nil {
+		shard.assignments[string(id)] = assigned
+	}
+	shard.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	shard.checkCapacity(lattice, endpointsPerCell, maximumOverlap)
+	return assigned, nil
+}
+
+// fits tells whether every endpoint of a shard is in the same cell of the
+// lattice.
+func fits(shard, lattice *Lattice) bool {
+	ok := true
+	shard.ForEachCell(func(sec, eps []string) bool {
+		all, err := lattice.GetEndpointsForSector(sec)
+		ok = err == nil
+		for i := 0; ok && i < len(eps); i++ {
+			ok = indexOf(all, eps[i]) >= 0
+		}
+		return ok
+	})
+
+	return ok
+}
+
+// Tenants returns the number of tenants which were assigned a shard.
+func (shard *StatefulSharder) Tenants() int {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return len(shard.assignments)
+}
+
+// Assignment returns the shard previously assigned to the tenant identified
+// by id, if there is one.
+func (shard *StatefulSharder) Assignment(id []byte) (*Lattice, bool) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	assigned, ok := shard.assignments[string(id)]
+	return assigned, ok
+}
+
+func (shard *StatefulSharder) allocate(lattice *Lattice, endpointsPerCell, maximumOverlap int) (*Lattice, error) {
+	m, start := loadMetrics(), time.Now()
+
+	shard.nodes = 0
+	targetLattice, err := shard.shuffleShardRecursiveHelper(lattice, endpointsPerCell, maximumOverlap, 0)
+	if err == nil && len(targetLattice.GetAllEndpoints()) == 0 {
+		err = ErrNoShards
+	}
+
+	if err != nil {
+		shard.observe(StatefulEvent{Kind: EventAllocationFailed, Err: err})
+	} else {
+		shard.observe(StatefulEvent{Kind: EventShardChosen, Shard: targetLattice})
+	}
+
+	if err == nil {
+		for _, fragment := range combinations.Combinations(targetLattice.GetAllEndpoints(), maximumOverlap+1) {
+			shard.saveFragment(fragment)
+		}
+		shard.endpointsPerCell, shard.maximumOverlap = endpointsPerCell, maximumOverlap
+	}
+
+	if m != nil {
+		observeShard(m, "stateful", start, err)
+		m.SearchNodes(shard.nodes)
+		m.FragmentsStored(shard.name, len(shard.store))
+	}
+
+	if err != nil {
+		return nil, err
 	}
 	return targetLattice, nil
 }
 
-func (shard *StatefulSharder) shuffleShardRecursiveHelper(lattice *Lattice, endpointsPerCell, maximumOverlap int) (*Lattice, error) {
-	allCoordinates := lattice.GetAllCoordinates()
+func (shard *StatefulSharder) shuffleShardRecursiveHelper(lattice *Lattice, endpointsPerCell, maximumOverlap, depth int) (*Lattice, error) {
+	shard.nodes++
+	var allCoordinates, allEndpoints [][]string
+	lattice.ForEachCell(func(sec, eps []string) bool {
+		allCoordinates, allEndpoints = append(allCoordinates, sec), append(allEndpoints, eps)
+		return true
+	})
 
-	rand.Shuffle(len(allCoordinates), func(i, j int) {
+	shard.random.Shuffle(len(allCoordinates), func(i, j int) {
 		allCoordinates[i], allCoordinates[j] = allCoordinates[j], allCoordinates[i]
+		allEndpoints[i], allEndpoints[j] = allEndpoints[j], allEndpoints[i]
 	})
-	for _, coordinate := range allCoordinates {
-		compliment, err := NewLattice(lattice.GetDimensionNames())
-		if err != nil {
-			return nil, err
-		}
-		err = deepcopy.Copy(compliment, lattice)
-		if err != nil {
-			return nil, err
-		}
+	for c, coordinate := range allCoordinates {
+		shard.observe(StatefulEvent{Kind: EventCoordinateTried, Depth: depth, Coordinate: coordinate})
+
+		var err error
+		compliment := lattice.Clone()
 		for i := 0; i < len(lattice.GetDimensionality()); i++ {
 			compliment, err = compliment.SimulateFailure(lattice.GetDimensionName(i), coordinate[i])
 			if err != nil {
@@ -62,25 +226,29 @@ func (shard *StatefulSharder) shuffleShardRecursiveHelper(lattice *Lattice, endp
 			}
 		}
 
-		endpoints, err := lattice.GetEndpointsForSector(coordinate)
-		if err != nil {
-			return nil, err
-		}
-		rand.Shuffle(len(endpoints), func(i, j int) {
+		endpoints := append([]string{}, allEndpoints[c]...)
+		shard.random.Shuffle(len(endpoints), func(i, j int) {
 			endpoints[i], endpoints[j] = endpoints[j], endpoints[i]
 		})
 		for _, fragment := range combinations.Combinations(endpoints, endpointsPerCell) {
 			if len(fragment) >= maximumOverlap && shard.areThereTooManyCollisions(fragment, maximumOverlap) {
+				shard.observe(StatefulEvent{Kind: EventFragmentRejected, Depth: depth, Coordinate: coordinate, Fragment: fragment, Reason: RejectedCollision})
 				continue
 			}
 
-			pickedRecursively, err := shard.shuffleShardRecursiveHelper(compliment, endpointsPerCell, maximumOverlap)
+			pickedRecursively, err := shard.shuffleShardRecursiveHelper(compliment, endpointsPerCell, maximumOverlap, depth+1)
 			if err != nil {
 				return nil, err
 			}
+			// An endpoint may be in several cells, but not twice in a shard.
+			if len(intersection(pickedRecursively.GetAllEndpoints(), fragment)) > 0 {
+				shard.observe(StatefulEvent{Kind: EventFragmentRejected, Depth: depth, Coordinate: coordinate, Fragment: fragment, Reason: RejectedDuplicate})
+				continue
+			}
 			combined := append(pickedRecursively.GetAllEndpoints(), fragment...)
 
 			if len(combined) >= maximumOverlap && shard.areThereTooManyCollisions(combined, maximumOverlap) {
+				shard.observe(StatefulEvent{Kind: EventFragmentRejected, Depth: depth, Coordinate: coordinate, Fragment: fragment, Reason: RejectedShardCollision})
 				continue
 			}
 
@@ -90,12 +258,87 @@ func (shard *StatefulSharder) shuffleShardRecursiveHelper(lattice *Lattice, endp
 		}
 	}
 
-	return NewLattice(lattice.GetDimensionNames())
+	// The search only runs out of cells, rather than out of endpoints that
+	// fit, once every cell is in the shard.
+	if len(allCoordinates) > 0 {
+		shard.observe(StatefulEvent{Kind: EventSearchExhausted, Depth: depth})
+	}
+	return newLattice(lattice.Seed, lattice.DimensionNames), nil
+}
+
+// FragmentsUsed returns the number of fragments taken by the shards
+// allocated so far.
+func (shard *StatefulSharder) FragmentsUsed() int {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return len(shard.store)
+}
+
+// Capacity estimates how many more shards can be allocated on the lattice
+// with the given parameters. Only fragments of maximumOverlap+1 endpoints
+// are accounted for.
+//
+// The estimate assumes every shard spans CellCapacity cells: sparse
+// lattices, where shards may span fewer, provide more shards than estimated.
+func (shard *StatefulSharder) Capacity(lattice *Lattice, endpointsPerCell, maximumOverlap int) StatefulCapacity {
+	var (
+		c    StatefulCapacity
+		size = endpointsPerCell * lattice.CellCapacity()
+	)
+
+	shard.mu.Lock()
+	for _, fragment := range shard.store {
+		if len(fragment) == maximumOverlap+1 {
+			c.FragmentsUsed++
+		}
+	}
+	shard.mu.Unlock()
+
+	c.FragmentsTotal = math.Round(math.Exp(
+		logBinomial(len(lattice.GetAllEndpoints()), maximumOverlap+1),
+	))
+	if c.FragmentsTotal > 0 {
+		c.Used = float64(c.FragmentsUsed) / c.FragmentsTotal
+	}
+
+	perShard := math.Round(math.Exp(logBinomial(size, maximumOverlap+1)))
+	if perShard > 0 {
+		c.Remaining = math.Floor(
+			(c.FragmentsTotal - float64(c.FragmentsUsed)) / perShard,
+		)
+	}
+
+	return c
+}
+
+// SetCapacityWarning installs a hook which is called after a shard is
+// allocated, whenever the fraction of used fragments reaches the threshold
+// (see Capacity). A nil hook removes it.
+func (shard *StatefulSharder) SetCapacityWarning(threshold float64, hook func(StatefulCapacity)) {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	shard.warnAt, shard.warnHook = threshold, hook
+}
+
+func (shard *StatefulSharder) checkCapacity(lattice *Lattice, endpointsPerCell, maximumOverlap int) {
+	shard.mu.Lock()
+	threshold, hook := shard.warnAt, shard.warnHook
+	shard.mu.Unlock()
+
+	if hook == nil {
+		return
+	}
+
+	if c := shard.Capacity(lattice, endpointsPerCell, maximumOverlap); c.Used >= threshold {
+		hook(c)
+	}
 }
 
 func (shard *StatefulSharder) saveFragment(fragment []string) {
 	sort.Strings(fragment)
-	shard.store[fmt.Sprintf("%v", fragment)] = true
+	shard.store[fmt.Sprintf("%v", fragment)] = fragment
 }
 
 func (shard *StatefulSharder) isFragmentUsed(fragment []string) bool {
@@ -211,3 +211,85 @@ func TestStatefulShuffleShardTwoDimensional(t *testing.T) {
 		}
 	}
 }
+
+func TestStatefulShuffleShardCapacity(t *testing.T) {
+	endpoints := []string{"A", "B", "C", "D", "E"}
+	lattice, err := shuffle.NewLattice([]string{"dimX"})
+	if err != nil {
+		t.Fatalf("unable to create new lattice: %v", err)
+	}
+	lattice.AddEndpointsForSector([]string{"x"}, endpoints)
+
+	sharder := shuffle.NewStatefulSharder()
+
+	// "5 choose 3" fragments, shards of 4 endpoints take "4 choose 3".
+	capacity := sharder.Capacity(lattice, 4, 2)
+	if capacity.FragmentsUsed != 0 || capacity.FragmentsTotal != 10 || capacity.Used != 0 || capacity.Remaining != 2 {
+		t.Fatalf("Unexpected capacity of an unused sharder: %+v", capacity)
+	}
+
+	var warned []shuffle.StatefulCapacity
+	sharder.SetCapacityWarning(0.3, func(c shuffle.StatefulCapacity) {
+		warned = append(warned, c)
+	})
+
+	if _, err := sharder.StatefulShuffleShard(lattice, 4, 2); err != nil {
+		t.Fatalf("Should have one valid shard from this config")
+	}
+
+	if sharder.FragmentsUsed() != 4 {
+		t.Fatalf("Expected 4 fragments to be used, got %d", sharder.FragmentsUsed())
+	}
+
+	capacity = sharder.Capacity(lattice, 4, 2)
+	if capacity.FragmentsUsed != 4 || capacity.Used != 0.4 || capacity.Remaining != 1 {
+		t.Fatalf("Unexpected capacity of a used sharder: %+v", capacity)
+	}
+
+	if len(warned) != 1 || warned[0] != capacity {
+		t.Fatalf("Capacity warning should have been raised once, got %+v", warned)
+	}
+}
+
+func TestStatefulShuffleShardDuplicates(t *testing.T) {
+	lattice, err := shuffle.NewLattice([]string{"dimX", "dimY"})
+	if err != nil {
+		t.Fatalf("unable to create new lattice: %v", err)
+	}
+	lattice.AddEndpointsForSector([]string{"x1", "y1"}, []string{"A", "B"})
+	lattice.AddEndpointsForSector([]string{"x2", "y2"}, []string{"A", "C"})
+	lattice.AddEndpointsForSector([]string{"x1", "y2"}, []string{"B", "D"})
+	lattice.AddEndpointsForSector([]string{"x2", "y1"}, []string{"C", "D"})
+
+	sharder := shuffle.NewStatefulSharder()
+	for i := 0; i < 4; i++ {
+		shard, err := sharder.StatefulShuffleShard(lattice, 1, 1)
+		if err != nil {
+			break
+		}
+
+		n := 0
+		for _, coordinate := range shard.GetAllCoordinates() {
+			endpoints, _ := shard.GetEndpointsForSector(coordinate)
+			n += len(endpoints)
+		}
+		if n != len(shard.GetAllEndpoints()) {
+			t.Fatalf("Endpoint picked twice for a shard: %v", shard.EndpointsByCoordinate)
+		}
+	}
+}
+
+func BenchmarkStatefulShuffleShard(b *testing.B) {
+	lattice := batchLattice(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		sharder := shuffle.NewStatefulSharderWithSeed(int64(n))
+		for i := 0; i < 10; i++ {
+			if _, err := sharder.StatefulShuffleShard(lattice, 2, 2); err != nil {
+				b.Fatalf("unable to shard the lattice: %v", err)
+			}
+		}
+	}
+}
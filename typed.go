@@ -0,0 +1,213 @@
+package shuffle
+
+import (
+	"fmt"
+)
+
+// TypedLattice is a lattice of endpoints of any comparable type, such as a
+// struct of an address and a port. It is backed by a Lattice of the string
+// keys of the endpoints, so it is sharded the same way, and its shards hold
+// the endpoints themselves.
+type TypedLattice[E comparable] struct {
+	lattice *Lattice
+	key     func(E) string
+	byKey   map[string]E
+}
+
+// NewTypedLattice creates a typed lattice with the given dimensions. The
+// key function turns an endpoint into the string it is sharded by, and must
+// be one-to-one; endpoints are formatted with fmt.Sprint if it is nil. See
+// NewLattice.
+func NewTypedLattice[E comparable](dims []string, key func(E) string) (
+	*TypedLattice[E], error,
+) {
+	return NewTypedLatticeWithSeed(seed, dims, key)
+}
+
+// NewTypedLatticeWithSeed is like NewTypedLattice, with the seed to use for
+// randomness; see NewLatticeWithSeed.
+func NewTypedLatticeWithSeed[E comparable](
+	seed int64, dims []string, key func(E) string,
+) (*TypedLattice[E], error) {
+	l, err := NewLatticeWithSeed(seed, dims)
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		key = func(e E) string { return fmt.Sprint(e) }
+	}
+
+	return &TypedLattice[E]{lattice: l, key: key, byKey: map[string]E{}}, nil
+}
+
+// Lattice returns the lattice of the keys of the endpoints, which backs the
+// typed lattice. It must only be modified through the typed lattice.
+func (t *TypedLattice[E]) Lattice() *Lattice {
+	return t.lattice
+}
+
+// Key returns the key of an endpoint.
+func (t *TypedLattice[E]) Key(e E) string {
+	return t.key(e)
+}
+
+// AddEndpointsForSector adds endpoints to the cell of a sector, see
+// Lattice.AddEndpointsForSector. Two distinct endpoints must not have the
+// same key.
+func (t *TypedLattice[E]) AddEndpointsForSector(sec []string, eps []E) error {
+	var (
+		keys  = make([]string, len(eps))
+		batch = make(map[string]E, len(eps))
+	)
+
+	for i, e := range eps {
+		k := t.key(e)
+		other, ok := batch[k]
+		if !ok {
+			other, ok = t.byKey[k]
+		}
+		if ok && other != e {
+			return fmt.Errorf(
+				"lattice: endpoints %v and %v have the same key %q",
+				other, e, k,
+			)
+		}
+		batch[k] = e
+		keys[i] = k
+	}
+
+	if err := t.lattice.AddEndpointsForSector(sec, keys); err != nil {
+		return err
+	}
+
+	for i, e := range eps {
+		t.byKey[keys[i]] = e
+	}
+
+	return nil
+}
+
+// endpoints returns the endpoints of keys.
+func (t *TypedLattice[E]) endpoints(keys []string) []E {
+	eps := make([]E, len(keys))
+	for i, k := range keys {
+		eps[i] = t.byKey[k]
+	}
+
+	return eps
+}
+
+// GetEndpointsForSector returns the endpoints in the cell of a sector, in
+// the order of their keys.
+func (t *TypedLattice[E]) GetEndpointsForSector(sec []string) ([]E, error) {
+	keys, err := t.lattice.GetEndpointsForSector(sec)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.endpoints(keys), nil
+}
+
+// AddEndpointsForCoordinate is AddEndpointsForSector, for the sector of a
+// coordinate.
+func (t *TypedLattice[E]) AddEndpointsForCoordinate(c Coordinate, eps []E) error {
+	sec, err := t.lattice.Sector(c)
+	if err != nil {
+		return err
+	}
+
+	return t.AddEndpointsForSector(sec, eps)
+}
+
+// GetEndpointsForCoordinate is GetEndpointsForSector, for the sector of a
+// coordinate.
+func (t *TypedLattice[E]) GetEndpointsForCoordinate(c Coordinate) ([]E, error) {
+	sec, err := t.lattice.Sector(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.GetEndpointsForSector(sec)
+}
+
+// GetAllEndpoints returns all the endpoints of the lattice, in the order of
+// their keys.
+func (t *TypedLattice[E]) GetAllEndpoints() []E {
+	return t.endpoints(t.lattice.GetAllEndpoints())
+}
+
+// GetAllCoordinates returns the sectors of all the cells of the lattice.
+func (t *TypedLattice[E]) GetAllCoordinates() [][]string {
+	return t.lattice.GetAllCoordinates()
+}
+
+// GetDimensionNames returns the dimensions of the lattice.
+func (t *TypedLattice[E]) GetDimensionNames() []string {
+	return t.lattice.GetDimensionNames()
+}
+
+// wrap returns the typed form of a lattice of the keys of endpoints of t,
+// such as a shard of it.
+func (t *TypedLattice[E]) wrap(l *Lattice) *TypedLattice[E] {
+	w := &TypedLattice[E]{lattice: l, key: t.key, byKey: map[string]E{}}
+	for _, k := range l.GetAllEndpoints() {
+		w.byKey[k] = t.byKey[k]
+	}
+
+	return w
+}
+
+// SimulateFailure returns the lattice without the cells of a dimension
+// value, see Lattice.SimulateFailure.
+func (t *TypedLattice[E]) SimulateFailure(dName, dVal string) (
+	*TypedLattice[E], error,
+) {
+	l, err := t.lattice.SimulateFailure(dName, dVal)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(l), nil
+}
+
+// SimpleShuffleShard computes the shuffle shard of an identification, see
+// Lattice.SimpleShuffleShard.
+func (t *TypedLattice[E]) SimpleShuffleShard(id []byte, epc int) (
+	*TypedLattice[E], error,
+) {
+	l, err := t.lattice.SimpleShuffleShard(id, epc)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(l), nil
+}
+
+// RendezvousShuffleShard computes the shuffle shard of an identification
+// with rendezvous hashing, see Lattice.RendezvousShuffleShard.
+func (t *TypedLattice[E]) RendezvousShuffleShard(id []byte, epc int) (
+	*TypedLattice[E], error,
+) {
+	l, err := t.lattice.RendezvousShuffleShard(id, epc)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(l), nil
+}
+
+// StatefulShuffleShard allocates a shard of the lattice with a stateful
+// sharder, see StatefulSharder.StatefulShuffleShard. The fragments of the
+// sharder are made of endpoint keys, so a sharder can be shared between a
+// typed lattice and its backing lattice.
+func (t *TypedLattice[E]) StatefulShuffleShard(
+	sharder *StatefulSharder, epc, maximumOverlap int,
+) (*TypedLattice[E], error) {
+	l, err := sharder.StatefulShuffleShard(t.lattice, epc, maximumOverlap)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.wrap(l), nil
+}
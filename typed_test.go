@@ -0,0 +1,159 @@
+package shuffle_test
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// host is a typed endpoint.
+type host struct {
+	IP   string
+	Port int
+}
+
+// TestTypedLattice tests lattices of typed endpoints, and their shards.
+func TestTypedLattice(t *testing.T) {
+	typed, err := shuffle.NewTypedLatticeWithSeed[host](
+		42, []string{"az", "version"}, nil,
+	)
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	plain, err := shuffle.NewLatticeWithSeed(42, []string{"az", "version"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	for i, az := range []string{"x", "y", "z"} {
+		for j, v := range []string{"1", "2", "3"} {
+			var eps []host
+			for k := 0; k < 4; k++ {
+				eps = append(eps, host{fmt.Sprintf("10.%d.%d.%d", i, j, k), 80})
+			}
+
+			if err = typed.AddEndpointsForSector([]string{az, v}, eps); err != nil {
+				t.Fatalf("unable to add endpoints: %v", err)
+			}
+
+			var keys []string
+			for _, e := range eps {
+				keys = append(keys, typed.Key(e))
+			}
+			plain.AddEndpointsForSector([]string{az, v}, keys)
+		}
+	}
+
+	if len(typed.GetAllEndpoints()) != 36 || len(typed.GetAllCoordinates()) != 9 {
+		t.Fatalf("illegal lattice: %v", typed.GetAllEndpoints())
+	}
+
+	eps, err := typed.GetEndpointsForSector([]string{"y", "3"})
+	if err != nil {
+		t.Fatalf("unable to fetch endpoints: %v", err)
+	}
+	if len(eps) != 4 || eps[0] != (host{"10.1.2.0", 80}) {
+		t.Fatalf("illegal endpoints returned: %v", eps)
+	}
+
+	// Shards are the same as the ones of the keys.
+	for i := 0; i < 50; i++ {
+		id := []byte(fmt.Sprintf("%d", i))
+
+		shd, err := typed.SimpleShuffleShard(id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+		ref, err := plain.SimpleShuffleShard(id, 2)
+		if err != nil {
+			t.Fatalf("unable to shard the lattice: %v", err)
+		}
+
+		got := shd.GetAllEndpoints()
+		for j, k := range ref.GetAllEndpoints() {
+			if typed.Key(got[j]) != k {
+				t.Fatalf(
+					"shards differ: expected %v, but got: %v",
+					ref.GetAllEndpoints(), got,
+				)
+			}
+		}
+	}
+
+	if _, err = typed.RendezvousShuffleShard([]byte("foo"), 1); err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+
+	sharder := shuffle.NewStatefulSharderWithSeed(1)
+	shd, err := typed.StatefulShuffleShard(sharder, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to shard the lattice: %v", err)
+	}
+	for _, e := range shd.GetAllEndpoints() {
+		if e.Port != 80 {
+			t.Fatalf("illegal endpoint in shard: %v", e)
+		}
+	}
+
+	sub, err := typed.SimulateFailure("az", "x")
+	if err != nil {
+		t.Fatalf("unable to simulate failure: %v", err)
+	}
+	if len(sub.GetAllEndpoints()) != 24 {
+		t.Fatalf("illegal lattice after failure: %v", sub.GetAllEndpoints())
+	}
+}
+
+// TestTypedLatticeKeys tests key functions, and their collisions.
+func TestTypedLatticeKeys(t *testing.T) {
+	l, err := shuffle.NewTypedLattice([]string{"az"}, func(a netip.AddrPort) string {
+		return a.Addr().String()
+	})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	a := netip.MustParseAddrPort("10.0.0.1:80")
+	b := netip.MustParseAddrPort("10.0.0.1:8080")
+
+	if err = l.AddEndpointsForSector([]string{"us-x"}, []netip.AddrPort{a}); err != nil {
+		t.Fatalf("unable to add endpoints: %v", err)
+	}
+	if err = l.AddEndpointsForCoordinate(shuffle.Coordinate{"az": "us-y"}, []netip.AddrPort{a}); err != nil {
+		t.Fatalf("unable to add the same endpoint again: %v", err)
+	}
+	if err = l.AddEndpointsForSector([]string{"us-y"}, []netip.AddrPort{b}); err == nil {
+		t.Fatalf("expected an error for endpoints with the same key")
+	}
+
+	// Collisions within the endpoints added at once are caught too.
+	c := netip.MustParseAddrPort("10.0.0.2:80")
+	d := netip.MustParseAddrPort("10.0.0.2:8080")
+	if err = l.AddEndpointsForSector([]string{"us-z"}, []netip.AddrPort{c, c}); err != nil {
+		t.Fatalf("unable to add the same endpoint twice: %v", err)
+	}
+	if err = l.AddEndpointsForSector([]string{"us-w"}, []netip.AddrPort{c, d}); err == nil {
+		t.Fatalf("expected an error for endpoints with the same key")
+	}
+
+	// Leave them out of the checks below.
+	l, err = l.SimulateFailure("az", "us-z")
+	if err != nil {
+		t.Fatalf("unable to simulate a failure: %v", err)
+	}
+
+	eps, err := l.GetEndpointsForCoordinate(shuffle.Coordinate{"az": "us-y"})
+	if err != nil || len(eps) != 1 || eps[0] != a {
+		t.Fatalf("illegal endpoints returned: %v, %v", eps, err)
+	}
+
+	if eps := l.GetAllEndpoints(); len(eps) != 1 || eps[0] != a {
+		t.Fatalf("illegal endpoints: %v", eps)
+	}
+	if l.Lattice().GetAllEndpoints()[0] != "10.0.0.1" {
+		t.Fatalf("illegal keys: %v", l.Lattice().GetAllEndpoints())
+	}
+}
@@ -0,0 +1,118 @@
+package shuffle
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ValidationError lists the structural problems found in a lattice by
+// Validate.
+type ValidationError struct {
+	Violations []string
+}
+
+// Error returns all the violations, separated by semicolons.
+func (e *ValidationError) Error() string {
+	return "lattice: invalid lattice: " + strings.Join(e.Violations, "; ")
+}
+
+// Validate checks the structural invariants of the lattice, which may be
+// broken by modifying its exported fields directly:
+//
+//   - dimension names are unique;
+//   - every key of EndpointsByCoordinate has one value per dimension;
+//   - every value of a sector is listed in ValuesByDimension, and every
+//     value in ValuesByDimension is used by a cell;
+//   - no cell is without endpoints;
+//   - no endpoint is in two cells, unless the lattice allows duplicates
+//     (see DuplicatePolicy).
+//
+// It returns nil for a valid lattice, and a *ValidationError listing every
+// violation otherwise.
+func (l *Lattice) Validate() error {
+	var (
+		v     []string
+		keys  []string
+		used  = map[string]map[string]bool{}
+		cells = map[string][]string{}
+	)
+
+	seen := map[string]bool{}
+	for _, d := range l.DimensionNames {
+		if seen[d] {
+			v = append(v, fmt.Sprintf("dimension %q is repeated", d))
+		}
+		seen[d] = true
+		used[d] = map[string]bool{}
+	}
+
+	for k := range l.EndpointsByCoordinate {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		sec := strings.Split(k, seperator)
+		if len(sec) != len(l.DimensionNames) {
+			v = append(v, fmt.Sprintf(
+				"sector %q has %d values for %d dimensions",
+				k, len(sec), len(l.DimensionNames),
+			))
+			continue
+		}
+
+		for i, d := range l.DimensionNames {
+			used[d][sec[i]] = true
+			if indexOf(l.ValuesByDimension[d], sec[i]) < 0 {
+				v = append(v, fmt.Sprintf(
+					"value %q of sector %v is not a value of dimension %q",
+					sec[i], sec, d,
+				))
+			}
+		}
+
+		if len(l.EndpointsByCoordinate[k]) == 0 {
+			v = append(v, fmt.Sprintf("sector %v has no endpoints", sec))
+		}
+
+		for _, ep := range set(l.EndpointsByCoordinate[k]) {
+			cells[ep] = append(cells[ep], fmt.Sprint(sec))
+		}
+	}
+
+	var unknown []string
+	for d := range l.ValuesByDimension {
+		if !seen[d] {
+			unknown = append(unknown, d)
+		}
+	}
+	for _, d := range set(unknown) {
+		v = append(v, fmt.Sprintf("values of unknown dimension %q", d))
+	}
+
+	for _, d := range set(l.DimensionNames) {
+		for _, val := range set(l.ValuesByDimension[d]) {
+			if !used[d][val] {
+				v = append(v, fmt.Sprintf(
+					"value %q of dimension %q has no cells", val, d,
+				))
+			}
+		}
+	}
+
+	for _, ep := range l.GetAllEndpoints() {
+		if l.Duplicates != AllowDuplicates && len(cells[ep]) > 1 {
+			v = append(v, fmt.Sprintf(
+				"endpoint %q is in sectors %s",
+				ep, strings.Join(cells[ep], ", "),
+			))
+		}
+	}
+
+	if len(v) > 0 {
+		return &ValidationError{v}
+	}
+
+	return nil
+}
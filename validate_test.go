@@ -0,0 +1,76 @@
+package shuffle_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/clickyotomy/go-shuffle-shard"
+)
+
+// TestValidate checks the structural invariants of lattices.
+func TestValidate(t *testing.T) {
+	l, err := shuffle.NewLattice([]string{"az", "go-lang"})
+	if err != nil {
+		t.Fatalf("unable to create a lattice: %v", err)
+	}
+
+	if err = l.Validate(); err != nil {
+		t.Fatalf("empty lattice is not valid: %v", err)
+	}
+
+	l.AddEndpointsForSector([]string{"us-x", "1.1"}, []string{"foo", "bar"})
+	l.AddEndpointsForSector([]string{"us-y", "0.3"}, []string{"baz"})
+
+	if err = l.Validate(); err != nil {
+		t.Fatalf("lattice is not valid: %v", err)
+	}
+
+	// Break every invariant.
+	l.Duplicates = shuffle.RejectDuplicates
+	l.EndpointsByCoordinate["us-z"] = []string{"qux"}
+	l.EndpointsByCoordinate["us-x⚡️0.3"] = []string{}
+	l.EndpointsByCoordinate["us-y⚡️1.1"] = []string{"foo"}
+	l.ValuesByDimension["az"] = append(l.ValuesByDimension["az"], "us-w")
+	l.ValuesByDimension["nginx"] = []string{"3"}
+
+	err = l.Validate()
+
+	var v *shuffle.ValidationError
+	if !errors.As(err, &v) {
+		t.Fatalf("expected a validation error, but got: %v", err)
+	}
+
+	expected := []string{
+		`sector [us-x 0.3] has no endpoints`,
+		`sector "us-z" has 1 values for 2 dimensions`,
+		`values of unknown dimension "nginx"`,
+		`value "us-w" of dimension "az" has no cells`,
+		`endpoint "foo" is in sectors [us-x 1.1], [us-y 1.1]`,
+	}
+	if !reflect.DeepEqual(v.Violations, expected) {
+		t.Fatalf(
+			"illegal violations returned:\nexpected: %q\nbut got:  %q",
+			expected, v.Violations,
+		)
+	}
+}
+
+// TestValidateDimensions checks that repeated dimensions are reported.
+func TestValidateDimensions(t *testing.T) {
+	l := &shuffle.Lattice{
+		DimensionNames: []string{"az", "az"},
+		ValuesByDimension: map[string][]string{
+			"az": {"us-x"},
+		},
+		EndpointsByCoordinate: map[string][]string{
+			"us-x⚡️us-x": {"foo"},
+		},
+	}
+
+	err := l.Validate()
+	if err == nil || err.Error() !=
+		`lattice: invalid lattice: dimension "az" is repeated` {
+		t.Fatalf("expected a repeated dimension, but got: %v", err)
+	}
+}